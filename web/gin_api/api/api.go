@@ -1,14 +1,20 @@
 package api
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
 	"github.com/jomifepe/gin_api/api/middleware"
 	routes "github.com/jomifepe/gin_api/api/resource"
+	"github.com/jomifepe/gin_api/blob"
 	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/mailer"
+	"github.com/jomifepe/gin_api/scheduler"
 	"github.com/jomifepe/gin_api/storage"
 	"github.com/jomifepe/gin_api/util"
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 // Start initializes the required resources, defines the API routes and starts listening for HTTP requests on <port>.
@@ -17,29 +23,95 @@ func Start(port string) {
 		"port": port,
 	}).Infoln("[API] Starting...")
 
+	if err := auth.LoadKeys(); err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Panicln("[API] Failed to load token signing keys")
+	}
+
+	mail, err := mailer.NewMailer()
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Panicln("[API] Failed to configure mailer")
+	}
+
+	blobs, err := blob.NewBlobStore()
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Panicln("[API] Failed to configure blob store")
+	}
+
 	dbConn := storage.ConfigurePostgresDB()
 
 	authStore := storage.NewAuthStore(dbConn)
 	taskStore := storage.NewTaskStore(dbConn)
 	userStore := storage.NewUserStore(dbConn)
+	projectStore := storage.NewProjectStore(dbConn)
+
+	recurrenceInterval := viper.GetDuration("RECURRENCE_INTERVAL")
+	if recurrenceInterval <= 0 {
+		logging.Logger.WithFields(logrus.Fields{
+			"interval": recurrenceInterval,
+		}).Panicln("[API] RECURRENCE_INTERVAL must be greater than 0")
+	}
+	scheduler.NewScheduler(taskStore, recurrenceInterval).Start(context.Background())
+	if retention := viper.GetDuration("TRASH_RETENTION"); retention > 0 {
+		purgeInterval := viper.GetDuration("TRASH_PURGE_INTERVAL")
+		if purgeInterval <= 0 {
+			logging.Logger.WithFields(logrus.Fields{
+				"interval": purgeInterval,
+			}).Panicln("[API] TRASH_PURGE_INTERVAL must be greater than 0")
+		}
+		scheduler.NewPurger(dbConn, blobs, purgeInterval, retention).Start(context.Background())
+	}
+
+	var attemptStore auth.AttemptStore = storage.NewAttemptStore(dbConn)
+	if viper.GetString("LOGIN_ATTEMPTS_STORE") == "memory" {
+		attemptStore = auth.NewMemoryAttemptStore()
+	}
+
+	var idempotencyStore middleware.IdempotencyStore = storage.NewIdempotencyStore(dbConn)
+	if viper.GetString("IDEMPOTENCY_STORE") == "memory" {
+		idempotencyStore = middleware.NewMemoryIdempotencyStore()
+	}
 
-	authResource := routes.NewAuthResource(authStore)
+	authResource := routes.NewAuthResource(authStore, auth.NewLoginGuard(attemptStore))
 	taskResource := routes.NewTaskResource(taskStore)
+	tagResource := routes.NewTagResource(taskStore)
+	commentResource := routes.NewCommentResource(taskStore)
+	attachmentResource := routes.NewAttachmentResource(taskStore, blobs)
 	userResource := routes.NewUserResource(userStore)
+	registrationResource := routes.NewRegistrationResource(userStore, mail)
+	passwordResource := routes.NewPasswordResource(authStore, mail)
+	twoFactorResource := routes.NewTwoFactorResource(authStore)
+	apiKeyResource := routes.NewAPIKeyResource(authStore)
+	projectResource := routes.NewProjectResource(projectStore, mail, blobs)
 
 	gin.SetMode(gin.ReleaseMode)
 	ginEngine := gin.New()
 	ginEngine.Use(middleware.Logger(logging.Logger), gin.Recovery())
 
 	authMiddleware := middleware.NewAuthMiddleware(authStore)
+	idempotencyMiddleware := middleware.NewIdempotencyMiddleware(idempotencyStore, viper.GetDuration("IDEMPOTENCY_KEY_TTL"),
+		viper.GetDuration("IDEMPOTENCY_LOCK_TIMEOUT"), viper.GetInt64("IDEMPOTENCY_MAX_BODY_SIZE"))
 
 	authResource.MountAuthRoutesTo(ginEngine, authMiddleware.AuthenticateToken())
-	authGroup := ginEngine.Group("", authMiddleware.AuthenticateToken()); {
+	registrationResource.MountRegistrationRoutesTo(ginEngine)
+	passwordResource.MountPasswordRoutesTo(ginEngine, authMiddleware.AuthenticateToken())
+	projectResource.MountInvitationRoutesTo(ginEngine)
+	authGroup := ginEngine.Group("", authMiddleware.AuthenticateToken(), idempotencyMiddleware.Idempotent()); {
 		taskResource.MountTaskRoutesTo(authGroup)
+		tagResource.MountTagRoutesTo(authGroup)
+		commentResource.MountCommentRoutesTo(authGroup)
+		attachmentResource.MountAttachmentRoutesTo(authGroup)
 		userResource.MountUserRoutesTo(authGroup)
+		twoFactorResource.MountTwoFactorRoutesTo(authGroup)
+		apiKeyResource.MountAPIKeyRoutesTo(authGroup)
+		projectResource.MountProjectRoutesTo(authGroup)
 	}
 
-	var err error
 	if util.FileExists("./cert.pem") && util.FileExists("./key.pem") {
 		err = ginEngine.RunTLS(":" + port, "cert.pem", "key.pem")
 	} else {
@@ -0,0 +1,27 @@
+package auth
+
+import "strings"
+
+const (
+	// APIKeyPrefix starts every API key, telling them apart from jwt tokens on the authorization header
+	APIKeyPrefix = "gak_"
+	// apiKeyHintLength is the number of characters of an API key that are stored in plain text, so that users can
+	// identify their keys when listing them
+	apiKeyHintLength = 8
+)
+
+// GenerateAPIKey creates a new API key, meant to be sent to the user, its hash, meant to be stored, and a short
+// hint of the key, meant to be shown to the user when listing its keys
+func GenerateAPIKey() (key string, hash string, hint string, err error) {
+	token, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, HashOpaqueToken(key), key[:len(APIKeyPrefix)+apiKeyHintLength], nil
+}
+
+// IsAPIKey checks if a token from the authorization header is an API key, rather than a jwt token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
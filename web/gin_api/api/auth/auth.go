@@ -10,18 +10,78 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+
+	// PurposeEmailVerification identifies the action tokens sent to users to verify their email address
+	PurposeEmailVerification = "email_verification"
+	// PurposeSecondFactor identifies the action tokens issued after a password sign in, that must be exchanged along
+	// with a second factor code for an access and refresh token pair
+	PurposeSecondFactor = "second_factor"
+)
+
+var (
+	// ErrTokenReused is returned when a refresh token that was already rotated (or revoked) is presented again
+	ErrTokenReused = errors.New("refresh token was already used")
 )
 
 type AccessToken struct {
-	UUID  string `json:"uuid"`
-	Token string `json:"token"`
+	UUID      string `json:"uuid"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
 }
 
+// TokenPair holds a short-lived access token and the long-lived refresh token that can be used to renew it.
+// Every pair belongs to a token family, which groups all the pairs obtained from the same sign in.
+type TokenPair struct {
+	Access     AccessToken `json:"access"`
+	Refresh    AccessToken `json:"refresh"`
+	FamilyUUID string      `json:"-"`
+}
+
+// AccessDetails is the database record of a signed in session. It tracks the current token pair of a token family,
+// along with the device it was obtained from.
 type AccessDetails struct {
-	ID          int    `json:"id"`
-	UserID      int    `json:"user_id"`
-	AccessUUID  string `json:"access_uuid"`
-	AccessToken string `json:"access_token"`
+	ID               int       `json:"id"`
+	UserID           int       `json:"user_id" gorm:"index"`
+	AccessUUID       string    `json:"access_uuid"`
+	AccessToken      string    `json:"access_token"`
+	RefreshUUID      string    `json:"refresh_uuid" gorm:"index"`
+	FamilyUUID       string    `json:"family_uuid" gorm:"index"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	Device           string    `json:"device"`
+	UserAgent        string    `json:"user_agent"`
+	IPAddress        string    `json:"ip_address"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Role             string    `json:"role" gorm:"-"`
+	// APIKeyID is the id of the API key used on the request, if it wasn't authenticated with a jwt token
+	APIKeyID int `json:"-" gorm:"-"`
+	// Scopes restricts the permissions of the Role, when authenticated with an API key. Nil means no restriction
+	Scopes []Permission `json:"-" gorm:"-"`
+}
+
+// Session is the public view of a signed in session (a token family), as listed to its user
+type Session struct {
+	UUID            string    `json:"uuid"`
+	Device          string    `json:"device"`
+	UserAgent       string    `json:"user_agent"`
+	IPAddress       string    `json:"ip_address"`
+	SignedInAt      time.Time `json:"signed_in_at"`
+	LastRefreshedAt time.Time `json:"last_refreshed_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Current         bool      `json:"current"`
+}
+
+// RefreshDetails holds the metadata extracted from a refresh token
+type RefreshDetails struct {
+	UserID      int
+	RefreshUUID string
+	FamilyUUID  string
 }
 
 func GeneratePassword(plainText string, cost ...int) (string, error) {
@@ -42,23 +102,128 @@ func ComparePasswords(inputPassword string, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(inputPassword))
 }
 
-func GenerateToken(id int, email string) (AccessToken, error) {
-	token := AccessToken{}
+// GenerateTokens creates a new access and refresh token pair for the user. The user role is embedded in the access
+// token claims. The pair joins the token family identified by <familyUUID>, or starts a new family if it's empty
+func GenerateTokens(id int, email string, role string, familyUUID string) (TokenPair, error) {
+	if len(familyUUID) == 0 {
+		fUUID, err := uuid.NewV4()
+		if err != nil {
+			return TokenPair{}, err
+		}
+		familyUUID = fUUID.String()
+	}
+
+	access, err := generateToken(viper.GetDuration("JWT_ACCESS_TTL"), jwt.MapClaims{
+		"token_type": tokenTypeAccess,
+		"user_id":    id,
+		"user_email": email,
+		"user_role":  role,
+	}, "access_uuid")
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refresh, err := generateToken(viper.GetDuration("JWT_REFRESH_TTL"), jwt.MapClaims{
+		"token_type":  tokenTypeRefresh,
+		"user_id":     id,
+		"family_uuid": familyUUID,
+	}, "refresh_uuid")
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{Access: access, Refresh: refresh, FamilyUUID: familyUUID}, nil
+}
+
+// AccessDetails returns the database record that tracks this token pair for the user with the specified id
+func (tp TokenPair) AccessDetails(userID int) AccessDetails {
+	return AccessDetails{
+		UserID:           userID,
+		AccessUUID:       tp.Access.UUID,
+		AccessToken:      tp.Access.Token,
+		RefreshUUID:      tp.Refresh.UUID,
+		FamilyUUID:       tp.FamilyUUID,
+		RefreshExpiresAt: time.Unix(tp.Refresh.ExpiresAt, 0),
+	}
+}
+
+// GenerateActionToken creates a token that allows the user to perform a single kind of action (<purpose>) outside of
+// a session, like verifying its email address, until it expires after <lifetime>
+func GenerateActionToken(purpose string, id int, email string, lifetime time.Duration) (string, error) {
+	token, err := generateToken(lifetime, jwt.MapClaims{
+		"token_type": purpose,
+		"user_id":    id,
+		"user_email": email,
+	}, "jti")
+	if err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// VerifyActionToken verifies a token created by GenerateActionToken for <purpose>, and returns the id and email
+// of the user it was created for
+func VerifyActionToken(encodedToken string, purpose string) (int, string, error) {
+	token, err := VerifyToken(encodedToken)
+	if err != nil {
+		return 0, "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["token_type"] != purpose {
+		return 0, "", errors.New("invalid token")
+	}
+	email, ok := claims["user_email"].(string)
+	if !ok {
+		return 0, "", errors.New("invalid token")
+	}
+	id, err := strconv.Atoi(fmt.Sprintf("%.f", claims["user_id"]))
+	if err != nil {
+		return 0, "", errors.New("invalid token")
+	}
+	return id, email, nil
+}
+
+// Session returns the public view of the session tracked by this access entry
+func (ad AccessDetails) Session() Session {
+	return Session{
+		UUID:            ad.FamilyUUID,
+		Device:          ad.Device,
+		UserAgent:       ad.UserAgent,
+		IPAddress:       ad.IPAddress,
+		SignedInAt:      ad.CreatedAt,
+		LastRefreshedAt: ad.UpdatedAt,
+		ExpiresAt:       ad.RefreshExpiresAt,
+	}
+}
+
+// generateToken signs a new token with the specified claims, identified by a random uuid stored under <uuidClaim>,
+// that expires after <lifetime>. The registered claims (iss, aud, iat, nbf and exp) are added to <claims>.
+func generateToken(lifetime time.Duration, claims jwt.MapClaims, uuidClaim string) (AccessToken, error) {
+	tUUID, err := uuid.NewV4()
+	if err != nil {
+		return AccessToken{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(lifetime).Unix()
+	claims[uuidClaim] = tUUID.String()
+	claims["iss"] = viper.GetString("JWT_ISSUER")
+	claims["aud"] = viper.GetString("JWT_AUDIENCE")
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = expiresAt
 
-	tUUID, _ := uuid.NewV4()
-	token.UUID = tUUID.String()
-	aClaims := jwt.MapClaims{}
-	aClaims["access_uuid"] = tUUID
-	aClaims["user_id"] = id
-	aClaims["user_email"] = email
-	tWithClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, aClaims)
-	tSigned, sErr := tWithClaims.SignedString([]byte(viper.GetString("JWT_ACCESS_SECRET")))
+	key, err := SigningKey()
+	if err != nil {
+		return AccessToken{}, err
+	}
+	tWithClaims := jwt.NewWithClaims(key.Method, claims)
+	tWithClaims.Header["kid"] = key.ID
+	tSigned, sErr := tWithClaims.SignedString(key.PrivateKey)
 	if sErr != nil {
 		return AccessToken{}, sErr
 	}
-
-	token.Token = tSigned
-	return token, nil
+	return AccessToken{UUID: tUUID.String(), Token: tSigned, ExpiresAt: expiresAt}, nil
 }
 
 func ValidateRequest(r *http.Request) error {
@@ -74,21 +239,64 @@ func ValidateToken(encodedToken string) error {
 	if err != nil {
 		return err
 	}
-	if _, ok := token.Claims.(jwt.Claims); !ok && !token.Valid {
+	if !token.Valid {
 		return errors.New("token doesn't meet jwt claims")
 	}
 	return nil
 }
 
-// VerifyToken parses a jwt token, checks it's signature and returns it as a jwt.Token or an
-// error if it isn't valid
+// VerifyToken parses a jwt token, checks it's signature against the key identified by its kid header and its
+// registered claims (see validateClaims), and returns it as a jwt.Token or an error if it isn't valid
 func VerifyToken(encodedToken string) (*jwt.Token, error) {
-	return jwt.Parse(encodedToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(encodedToken, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("invalid token: %v", token.Header["alg"])
 		}
-		return []byte(viper.GetString("JWT_ACCESS_SECRET")), nil
+		return key.PublicKey, nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	if err = validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// validateClaims checks that all the registered claims are present and valid: the token must have been issued by
+// and for this API (JWT_ISSUER and JWT_AUDIENCE), and the current time must be between its iat/nbf and exp claims.
+// Timestamps are compared with a tolerance of JWT_CLOCK_SKEW, to account for clock differences between servers.
+func validateClaims(claims jwt.MapClaims) error {
+	var (
+		now  = time.Now()
+		skew = viper.GetDuration("JWT_CLOCK_SKEW")
+	)
+	if !claims.VerifyExpiresAt(now.Add(-skew).Unix(), true) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(skew).Unix(), true) {
+		return errors.New("token used before issued")
+	}
+	if !claims.VerifyNotBefore(now.Add(skew).Unix(), true) {
+		return errors.New("token is not valid yet")
+	}
+	if !claims.VerifyIssuer(viper.GetString("JWT_ISSUER"), true) {
+		return errors.New("invalid token issuer")
+	}
+	if !claims.VerifyAudience(viper.GetString("JWT_AUDIENCE"), true) {
+		return errors.New("invalid token audience")
+	}
+	return nil
 }
 
 func ExtractRequestTokenMetadata(r *http.Request) (AccessDetails, error) {
@@ -106,19 +314,47 @@ func ExtractTokenMetadata(encodedToken string) (AccessDetails, error) {
 		return AccessDetails{}, err
 	}
 
-	t := AccessDetails{}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		t.AccessToken = encodedToken
-		if t.AccessUUID, ok = claims["access_uuid"].(string); !ok {
-			return AccessDetails{}, errors.New("invalid token")
-		}
-		if t.UserID, err = strconv.Atoi(fmt.Sprintf("%.f", claims["user_id"])); err != nil {
-			return AccessDetails{}, errors.New("invalid token")
-		}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["token_type"] != tokenTypeAccess {
+		return AccessDetails{}, errors.New("invalid token")
+	}
+	t := AccessDetails{AccessToken: encodedToken}
+	if t.AccessUUID, ok = claims["access_uuid"].(string); !ok {
+		return AccessDetails{}, errors.New("invalid token")
+	}
+	if t.Role, ok = claims["user_role"].(string); !ok {
+		return AccessDetails{}, errors.New("invalid token")
+	}
+	if t.UserID, err = strconv.Atoi(fmt.Sprintf("%.f", claims["user_id"])); err != nil {
+		return AccessDetails{}, errors.New("invalid token")
 	}
 	return t, nil
 }
 
+// ExtractRefreshTokenMetadata verifies a refresh token and returns the user, token and family it belongs to
+func ExtractRefreshTokenMetadata(encodedToken string) (RefreshDetails, error) {
+	token, err := VerifyToken(encodedToken)
+	if err != nil {
+		return RefreshDetails{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["token_type"] != tokenTypeRefresh {
+		return RefreshDetails{}, errors.New("invalid token")
+	}
+	rd := RefreshDetails{}
+	if rd.RefreshUUID, ok = claims["refresh_uuid"].(string); !ok {
+		return RefreshDetails{}, errors.New("invalid token")
+	}
+	if rd.FamilyUUID, ok = claims["family_uuid"].(string); !ok {
+		return RefreshDetails{}, errors.New("invalid token")
+	}
+	if rd.UserID, err = strconv.Atoi(fmt.Sprintf("%.f", claims["user_id"])); err != nil {
+		return RefreshDetails{}, errors.New("invalid token")
+	}
+	return rd, nil
+}
+
 func ExtractTokenFromRequest(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	tokenString := strings.Split(authHeader, " ")
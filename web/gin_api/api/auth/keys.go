@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// Key is a key used to sign or verify tokens, identified by its kid (the RFC 7638 thumbprint of its public key).
+// Verification only keys, kept around while tokens signed by them are still valid, have no private key.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// JSONWebKey is the public part of a Key, encoded as a JWK (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the set of public keys that can be used to verify the tokens issued by the API
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+var (
+	keysMutex  sync.RWMutex
+	signingKey *Key
+	keys       = map[string]*Key{}
+)
+
+// LoadKeys reads the private key used to sign new tokens from JWT_SIGNING_KEY_FILE and the public (or private) keys
+// from JWT_VERIFICATION_KEY_FILES, a comma separated list of PEM files, that are still accepted when verifying tokens.
+// To rotate keys, point JWT_SIGNING_KEY_FILE to the new key and add the previous one to JWT_VERIFICATION_KEY_FILES.
+// If the signing key file doesn't exist, a new Ed25519 key is generated and written to it, so that tokens
+// keep being valid across restarts.
+func LoadKeys() error {
+	signingKeyFile := viper.GetString("JWT_SIGNING_KEY_FILE")
+	if len(signingKeyFile) == 0 {
+		return errors.New("no signing key file specified")
+	}
+	if !util.FileExists(signingKeyFile) {
+		if err := writeNewSigningKey(signingKeyFile); err != nil {
+			return err
+		}
+		logging.Logger.WithFields(logrus.Fields{
+			"file": signingKeyFile,
+		}).Warnln("[AUTH] No signing key found, generated a new one")
+	}
+
+	signing, err := readKeyFile(signingKeyFile)
+	if err != nil {
+		return err
+	}
+	if signing.PrivateKey == nil {
+		return fmt.Errorf("%v doesn't contain a private key", signingKeyFile)
+	}
+
+	loaded := map[string]*Key{signing.ID: signing}
+	for _, file := range strings.Split(viper.GetString("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if file = strings.TrimSpace(file); len(file) == 0 {
+			continue
+		}
+		key, err := readKeyFile(file)
+		if err != nil {
+			return err
+		}
+		if _, exists := loaded[key.ID]; !exists {
+			key.PrivateKey = nil
+			loaded[key.ID] = key
+		}
+	}
+
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+	signingKey, keys = signing, loaded
+	return nil
+}
+
+// SigningKey returns the key used to sign new tokens
+func SigningKey() (*Key, error) {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+	if signingKey == nil {
+		return nil, errors.New("no signing key loaded")
+	}
+	return signingKey, nil
+}
+
+// VerificationKey returns the key identified by <kid>, if it's still accepted to verify tokens
+func VerificationKey(kid string) (*Key, error) {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %v", kid)
+	}
+	return key, nil
+}
+
+// JWKS returns every key accepted to verify tokens as a JSON Web Key Set
+func JWKS() JSONWebKeySet {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
+
+// JWK returns the public part of the key, encoded as a JSON Web Key
+func (k *Key) JWK() JSONWebKey {
+	jwk := JSONWebKey{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// newKey builds a Key from a parsed private or public key, choosing the signing method from the key type
+// (RS256 for RSA keys and EdDSA for Ed25519 keys)
+func newKey(parsed interface{}) (*Key, error) {
+	key := &Key{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.PublicKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.PrivateKey, key.PublicKey = SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.PublicKey = SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", parsed)
+	}
+
+	// RFC 7638 thumbprint: the required members of the JWK, in lexicographic order and without whitespace
+	var thumbprintInput []byte
+	switch jwk := key.JWK(); jwk.Kty {
+	case "RSA":
+		thumbprintInput, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N})
+	case "OKP":
+		thumbprintInput, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X})
+	}
+	thumbprint := sha256.Sum256(thumbprintInput)
+	key.ID = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	return key, nil
+}
+
+// readKeyFile parses the first PEM block of a file as a PKCS #1, PKCS #8 or PKIX encoded key
+func readKeyFile(file string) (*Key, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%v doesn't contain a PEM encoded key", file)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block type: %v", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", file, err)
+	}
+	return newKey(parsed)
+}
+
+// writeNewSigningKey generates a new Ed25519 key and writes it to <file>, PKCS #8 encoded
+func writeNewSigningKey(file string) error {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginAttempts is the failed sign in attempts counter of an account or IP address (identified by Key).
+// Failures older than LOGIN_FAILURE_WINDOW are forgotten when a new one is registered.
+type LoginAttempts struct {
+	Key           string     `json:"key" gorm:"primaryKey"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// AttemptStore keeps the failed sign in attempts counters
+type AttemptStore interface {
+	// GetLoginAttempts returns the counter identified by <key>, or an empty one if there's none
+	GetLoginAttempts(key string) (LoginAttempts, error)
+	// IncrementLoginAttempts atomically registers a failure at <now> on the counter identified by <key>, restarting
+	// it if its last failure is older than <window>, and returns the updated counter
+	IncrementLoginAttempts(key string, now time.Time, window time.Duration) (LoginAttempts, error)
+	// LockLogin prevents sign in attempts on the counter identified by <key> until <until>
+	LockLogin(key string, until time.Time) error
+	// ResetLoginAttempts deletes the counter identified by <key>
+	ResetLoginAttempts(key string) error
+}
+
+// LoginGuard protects the sign in against brute-force attacks, by tracking failed attempts per account and per IP.
+// After LOGIN_DELAY_THRESHOLD failures, each attempt must wait a delay that doubles with every failure (starting
+// at LOGIN_DELAY_BASE, up to LOGIN_DELAY_MAX). After LOGIN_MAX_ACCOUNT_FAILURES failures on an account (or
+// LOGIN_MAX_IP_FAILURES on an IP address), attempts are locked for LOGIN_LOCKOUT_DURATION.
+type LoginGuard struct {
+	Store AttemptStore
+}
+
+// NewLoginGuard initializes the LoginGuard with an existing AttemptStore
+func NewLoginGuard(store AttemptStore) *LoginGuard {
+	return &LoginGuard{
+		Store: store,
+	}
+}
+
+// Check returns how long a sign in attempt on the account with the specified email, from the specified IP address,
+// must wait before being allowed. Zero means it's allowed right away.
+func (g *LoginGuard) Check(email string, ip string) (time.Duration, error) {
+	var (
+		now  = time.Now()
+		wait time.Duration
+	)
+	for _, key := range attemptKeys(email, ip) {
+		attempts, err := g.Store.GetLoginAttempts(key)
+		if err != nil {
+			return 0, err
+		}
+		if attempts.LockedUntil != nil && attempts.LockedUntil.After(now) {
+			wait = maxDuration(wait, attempts.LockedUntil.Sub(now))
+			continue
+		}
+		if retryAt := attempts.LastFailureAt.Add(attemptDelay(attempts.Failures)); retryAt.After(now) {
+			wait = maxDuration(wait, retryAt.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// RegisterFailure registers a failed sign in attempt on the account with the specified email, from the specified
+// IP address, locking them if they reached their maximum number of failures
+func (g *LoginGuard) RegisterFailure(email string, ip string) error {
+	var (
+		now    = time.Now()
+		window = viper.GetDuration("LOGIN_FAILURE_WINDOW")
+		keys   = attemptKeys(email, ip)
+		limits = []int{viper.GetInt("LOGIN_MAX_ACCOUNT_FAILURES"), viper.GetInt("LOGIN_MAX_IP_FAILURES")}
+	)
+	for i, key := range keys {
+		attempts, err := g.Store.IncrementLoginAttempts(key, now, window)
+		if err != nil {
+			return err
+		}
+		if attempts.Failures < limits[i] {
+			continue
+		}
+
+		lockedUntil := now.Add(viper.GetDuration("LOGIN_LOCKOUT_DURATION"))
+		if err = g.Store.LockLogin(key, lockedUntil); err != nil {
+			return err
+		}
+		logging.Logger.WithFields(logrus.Fields{
+			"audit":        true,
+			"key":          key,
+			"failures":     attempts.Failures,
+			"locked_until": lockedUntil,
+		}).Warnln("[AUTH|AUDIT] Sign in locked after too many failed attempts")
+	}
+	return nil
+}
+
+// RegisterSuccess clears the failed attempts of the account with the specified email, after a successful sign in
+func (g *LoginGuard) RegisterSuccess(email string) error {
+	return g.Store.ResetLoginAttempts(attemptKeys(email, "")[0])
+}
+
+// Unlock clears the failed attempts and lockout of the account with the specified email. <actorID> is the id of the
+// user that unlocked it, recorded on the audit log
+func (g *LoginGuard) Unlock(email string, actorID int) error {
+	key := attemptKeys(email, "")[0]
+	if err := g.Store.ResetLoginAttempts(key); err != nil {
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"key":      key,
+		"actor_id": actorID,
+	}).Warnln("[AUTH|AUDIT] Sign in unlocked")
+	return nil
+}
+
+// attemptKeys returns the counter keys of an account email and an IP address
+func attemptKeys(email string, ip string) []string {
+	return []string{"account:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + ip}
+}
+
+// attemptDelay returns how long to wait after the last of <failures> failed attempts
+func attemptDelay(failures int) time.Duration {
+	threshold := viper.GetInt("LOGIN_DELAY_THRESHOLD")
+	if failures < threshold {
+		return 0
+	}
+	delay := float64(viper.GetDuration("LOGIN_DELAY_BASE")) * math.Pow(2, float64(failures-threshold))
+	return time.Duration(math.Min(delay, float64(viper.GetDuration("LOGIN_DELAY_MAX"))))
+}
+
+func maxDuration(a time.Duration, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// MemoryAttemptStore is an AttemptStore that keeps the counters in memory. Counters are lost on restart and aren't
+// shared between instances, so it's only meant for single instance deployments and development.
+type MemoryAttemptStore struct {
+	mutex    sync.Mutex
+	attempts map[string]LoginAttempts
+}
+
+// NewMemoryAttemptStore returns an empty MemoryAttemptStore
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: map[string]LoginAttempts{},
+	}
+}
+
+func (s *MemoryAttemptStore) GetLoginAttempts(key string) (LoginAttempts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if attempts, ok := s.attempts[key]; ok {
+		return attempts, nil
+	}
+	return LoginAttempts{Key: key}, nil
+}
+
+func (s *MemoryAttemptStore) IncrementLoginAttempts(key string, now time.Time, window time.Duration) (LoginAttempts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok || attempts.LastFailureAt.Before(now.Add(-window)) {
+		attempts.Failures = 0
+	}
+	attempts.Key = key
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+func (s *MemoryAttemptStore) LockLogin(key string, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	attempts := s.attempts[key]
+	attempts.Key = key
+	attempts.LockedUntil = &until
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryAttemptStore) ResetLoginAttempts(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,52 @@
+package auth
+
+import "github.com/jomifepe/gin_api/model"
+
+// Permission is an action that a user role may be allowed to perform
+type Permission string
+
+const (
+	PermissionReadUsers  Permission = "users:read"
+	PermissionWriteUsers Permission = "users:write"
+	PermissionReadTasks  Permission = "tasks:read"
+	PermissionWriteTasks Permission = "tasks:write"
+)
+
+var (
+	rolePermissions = map[string][]Permission{
+		model.RoleAdmin: {
+			PermissionReadUsers, PermissionWriteUsers,
+			PermissionReadTasks, PermissionWriteTasks,
+		},
+		model.RoleMember: {
+			PermissionReadTasks, PermissionWriteTasks,
+		},
+	}
+)
+
+// HasPermission checks if users with the specified role are allowed to perform the action <permission>
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows checks if the access is allowed to perform the action <permission>. Its role must grant it and, if it was
+// authenticated with an API key, the permission must be one of the key scopes
+func (ad AccessDetails) Allows(permission Permission) bool {
+	if !HasPermission(ad.Role, permission) {
+		return false
+	}
+	if ad.Scopes == nil {
+		return true
+	}
+	for _, scope := range ad.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 test vectors (SHA1), truncated to 6 digits
+func TestValidateTOTP(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, code := range vectors {
+		step, ok := ValidateTOTP(secret, code, time.Unix(unix, 0))
+		if !ok {
+			t.Errorf("Expected code %v to be valid at %v, but it wasn't", code, unix)
+		}
+		if expected := unix / totpPeriod; step != expected {
+			t.Errorf("Expected step %v for code %v, but got %v", expected, code, step)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if _, ok := ValidateTOTP(secret, "287082", time.Unix(59+totpPeriod, 0)); !ok {
+		t.Errorf("Expected code of the previous period to be valid, but it wasn't")
+	}
+	if _, ok := ValidateTOTP(secret, "287082", time.Unix(59+3*totpPeriod, 0)); ok {
+		t.Errorf("Expected code from 3 periods ago to be invalid, but it was valid")
+	}
+	if _, ok := ValidateTOTP(secret, "28708", time.Unix(59, 0)); ok {
+		t.Errorf("Expected code with the wrong length to be invalid, but it was valid")
+	}
+}
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	idempotencyKeyMaxLength = 255
+)
+
+var (
+	idempotencyKeyInvalidMessage    = gin.H{"message": "The Idempotency-Key header can't have more than 255 characters"}
+	idempotencyKeyReusedMessage     = gin.H{"message": "The Idempotency-Key was already used with a different request"}
+	idempotencyKeyInProgressMessage = gin.H{"message": "A request with the same Idempotency-Key is still being processed, please retry later"}
+	idempotencyBodyMessage          = gin.H{"message": "Couldn't read the request body"}
+	idempotencyBodyTooLargeMessage  = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("Requests with an Idempotency-Key must not be larger than %v bytes", param...)}
+	}
+)
+
+// IdempotentResponse is the response to the first request of a user (identified by UserID) with an Idempotency-Key
+// (Key), replayed on the retries of the same request (identified by RequestHash) until ExpiresAt. Its Status is 0
+// while the first request is still being processed, which is assumed to have been interrupted after LockedUntil
+type IdempotentResponse struct {
+	UserID      int    `gorm:"primaryKey;autoIncrement:false"`
+	Key         string `gorm:"primaryKey"`
+	RequestHash string
+	Status      int
+	ContentType string
+	ETag        string
+	Body        []byte
+	CreatedAt   time.Time
+	LockedUntil time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// IdempotencyStore keeps the responses to the requests with an Idempotency-Key
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey atomically saves <response>, still without a status, unless there's a response with the
+	// same user and key that didn't expire at <now>, in which case it returns that one and false. Responses still
+	// without a status whose lock expired at <now> are replaced
+	ReserveIdempotencyKey(response IdempotentResponse, now time.Time) (IdempotentResponse, bool, error)
+	// SaveIdempotentResponse sets the status, content type, ETag and body of the reserved response with the same
+	// user and key as <response>
+	SaveIdempotentResponse(response IdempotentResponse) error
+	// ReleaseIdempotencyKey deletes the response with the user id <userID> and the key <key>, if it's still reserved,
+	// so that its request can be retried
+	ReleaseIdempotencyKey(userID int, key string) error
+}
+
+type IdempotencyMiddleware struct {
+	Store       IdempotencyStore
+	TTL         time.Duration
+	LockTimeout time.Duration
+	MaxBodySize int64
+}
+
+// NewIdempotencyMiddleware initializes the IdempotencyMiddleware with an existing IdempotencyStore, keeping the
+// responses for <ttl>, and the keys of the requests still being processed for <lockTimeout>, after which they're
+// assumed to have been interrupted (e.g. by a crash) and can be retried. Request bodies of up to <maxBodySize> bytes
+// are accepted
+func NewIdempotencyMiddleware(store IdempotencyStore, ttl time.Duration, lockTimeout time.Duration, maxBodySize int64) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		Store:       store,
+		TTL:         ttl,
+		LockTimeout: lockTimeout,
+		MaxBodySize: maxBodySize,
+	}
+}
+
+// Idempotent is a middleware for gin that makes the POST, PUT, PATCH and DELETE requests with an Idempotency-Key header
+// safe to retry. It must come after AuthenticateToken, since the keys are scoped by user. The response to the first
+// request with a key is stored, and replayed on the retries with the same method, path and body (with an
+// Idempotent-Replayed header), until it expires. Reusing the key with a different request is rejected with a
+// http.StatusUnprocessableEntity status code, and retrying while the first request is still being processed with a
+// http.StatusConflict status code, unless it's been longer than LockTimeout. Server errors aren't stored, so their
+// requests can be retried with the same key. The body is read into memory to be compared, so requests with a key and a
+// body larger than MaxBodySize are rejected with a http.StatusRequestEntityTooLarge status code. If the store fails,
+// the request is processed as if it had no key
+func (im *IdempotencyMiddleware) Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		access, ok := GetAccessDetails(c)
+		if len(key) == 0 || !ok || !isIdempotencyMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, idempotencyKeyInvalidMessage)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, im.MaxBodySize+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, idempotencyBodyMessage)
+			return
+		}
+		if int64(len(body)) > im.MaxBodySize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, idempotencyBodyTooLargeMessage(im.MaxBodySize))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		now, hash := time.Now(), requestHash(c.Request, body)
+		response, reserved, err := im.Store.ReserveIdempotencyKey(IdempotentResponse{
+			UserID:      access.UserID,
+			Key:         key,
+			RequestHash: hash,
+			CreatedAt:   now,
+			LockedUntil: now.Add(im.LockTimeout),
+			ExpiresAt:   now.Add(im.TTL),
+		}, now)
+		if err != nil {
+			c.Next()
+			return
+		}
+		if !reserved {
+			replayResponse(c, response, hash)
+			return
+		}
+
+		// the key is released unless the response is saved, including when the handler panics
+		saved := false
+		defer func() {
+			if !saved {
+				_ = im.Store.ReleaseIdempotencyKey(access.UserID, key)
+			}
+		}()
+		writer := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		response.Status = writer.Status()
+		response.ContentType = writer.Header().Get("Content-Type")
+		response.ETag = writer.Header().Get("ETag")
+		response.Body = writer.body.Bytes()
+		if err := im.Store.SaveIdempotentResponse(response); err != nil {
+			logging.Logger.WithFields(logrus.Fields{
+				"user_id": access.UserID,
+				"error": err,
+			}).Warnln("[API] Failed to save idempotent response")
+			return
+		}
+		saved = true
+	}
+}
+
+// replayResponse responds with a stored response, if it's the response to the same request (identified by <hash>)
+// and it's complete, or otherwise aborts the request
+func replayResponse(c *gin.Context, response IdempotentResponse, hash string) {
+	switch {
+	case response.RequestHash != hash:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, idempotencyKeyReusedMessage)
+	case response.Status == 0:
+		c.AbortWithStatusJSON(http.StatusConflict, idempotencyKeyInProgressMessage)
+	default:
+		c.Header(idempotentReplayHeader, "true")
+		if len(response.ETag) > 0 {
+			c.Header("ETag", response.ETag)
+		}
+		if len(response.Body) > 0 {
+			c.Data(response.Status, response.ContentType, response.Body)
+		} else {
+			c.Status(response.Status)
+		}
+		c.Abort()
+	}
+}
+
+// isIdempotencyMethod returns true for the request methods that honour the Idempotency-Key header
+func isIdempotencyMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestHash returns the hex encoded SHA-256 hash of the method, path (with the query) and body of a request
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder is a gin.ResponseWriter that keeps a copy of the response body
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps the responses in memory. Responses are lost on restart and
+// aren't shared between instances, so it's only meant for single instance deployments and development.
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	responses map[idempotencyKey]IdempotentResponse
+}
+
+// idempotencyKey identifies a response on a MemoryIdempotencyStore
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		responses: map[idempotencyKey]IdempotentResponse{},
+	}
+}
+
+func (s *MemoryIdempotencyStore) ReserveIdempotencyKey(response IdempotentResponse, now time.Time) (IdempotentResponse, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, stored := range s.responses {
+		if !stored.ExpiresAt.After(now) || (stored.Status == 0 && !stored.LockedUntil.After(now)) {
+			delete(s.responses, id)
+		}
+	}
+	id := idempotencyKey{userID: response.UserID, key: response.Key}
+	if stored, ok := s.responses[id]; ok {
+		return stored, false, nil
+	}
+	response.Status = 0
+	s.responses[id] = response
+	return response, true, nil
+}
+
+func (s *MemoryIdempotencyStore) SaveIdempotentResponse(response IdempotentResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := idempotencyKey{userID: response.UserID, key: response.Key}
+	if _, ok := s.responses[id]; ok {
+		s.responses[id] = response
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) ReleaseIdempotencyKey(userID int, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := idempotencyKey{userID: userID, key: key}
+	if stored, ok := s.responses[id]; ok && stored.Status == 0 {
+		delete(s.responses, id)
+	}
+	return nil
+}
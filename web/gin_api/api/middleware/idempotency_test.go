@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewMemoryIdempotencyStore()
+	calls := 0
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set(accessDetailsKey, auth.AccessDetails{UserID: userID})
+	}, NewIdempotencyMiddleware(store, time.Hour, time.Minute, 16).Idempotent())
+	handler := func(c *gin.Context) {
+		calls++
+		body, _ := c.GetRawData()
+		if string(body) == "fail" {
+			c.JSON(http.StatusInternalServerError, gin.H{"call": calls})
+			return
+		}
+		c.Header("ETag", fmt.Sprintf(`"%v"`, calls))
+		c.JSON(http.StatusCreated, gin.H{"call": calls, "body": string(body)})
+	}
+	engine.POST("/tasks", handler)
+	engine.GET("/tasks", handler)
+	engine.DELETE("/tasks", func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusNoContent)
+	})
+
+	request := func(method string, user string, key string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/tasks", strings.NewReader(body))
+		r.Header.Set("X-User", user)
+		if len(key) > 0 {
+			r.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		user     string
+		key      string
+		body     string
+		status   int
+		calls    int
+		replayed bool
+		response string
+	}{
+		{"no key", http.MethodPost, "1", "", "a", http.StatusCreated, 1, false, `{"body":"a","call":1}`},
+		{"no key retried", http.MethodPost, "1", "", "a", http.StatusCreated, 2, false, `{"body":"a","call":2}`},
+		{"first", http.MethodPost, "1", "k1", "a", http.StatusCreated, 3, false, `{"body":"a","call":3}`},
+		{"retry", http.MethodPost, "1", "k1", "a", http.StatusCreated, 3, true, `{"body":"a","call":3}`},
+		{"different body", http.MethodPost, "1", "k1", "b", http.StatusUnprocessableEntity, 3, false, ""},
+		{"different method", http.MethodDelete, "1", "k1", "a", http.StatusUnprocessableEntity, 3, false, ""},
+		{"other user", http.MethodPost, "2", "k1", "a", http.StatusCreated, 4, false, `{"body":"a","call":4}`},
+		{"safe method", http.MethodGet, "1", "k1", "", http.StatusCreated, 5, false, `{"body":"","call":5}`},
+		{"server error", http.MethodPost, "1", "k2", "fail", http.StatusInternalServerError, 6, false, ""},
+		{"server error retried", http.MethodPost, "1", "k2", "fail", http.StatusInternalServerError, 7, false, ""},
+		{"no content", http.MethodDelete, "1", "k3", "", http.StatusNoContent, 8, false, ""},
+		{"no content retried", http.MethodDelete, "1", "k3", "", http.StatusNoContent, 8, true, ""},
+		{"long key", http.MethodPost, "1", strings.Repeat("k", 256), "a", http.StatusBadRequest, 8, false, ""},
+		{"large body", http.MethodPost, "1", "k5", strings.Repeat("a", 17), http.StatusRequestEntityTooLarge, 8, false, ""},
+		{"large body without key", http.MethodPost, "1", "", strings.Repeat("a", 17), http.StatusCreated, 9, false, ""},
+		{"body at the limit", http.MethodPost, "1", "k5", strings.Repeat("a", 16), http.StatusCreated, 10, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(tt.method, tt.user, tt.key, tt.body)
+			if w.Code != tt.status {
+				t.Errorf("status = %v, want %v", w.Code, tt.status)
+			}
+			if calls != tt.calls {
+				t.Errorf("calls = %v, want %v", calls, tt.calls)
+			}
+			if replayed := w.Header().Get(idempotentReplayHeader) == "true"; replayed != tt.replayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.replayed)
+			}
+			if len(tt.response) > 0 && w.Body.String() != tt.response {
+				t.Errorf("body = %v, want %v", w.Body.String(), tt.response)
+			}
+		})
+	}
+
+	if w := request(http.MethodPost, "1", "k1", "a"); w.Header().Get("ETag") != `"3"` {
+		t.Errorf("replayed ETag = %v, want \"3\"", w.Header().Get("ETag"))
+	}
+
+	now := time.Now()
+	hash := requestHash(httptest.NewRequest(http.MethodPost, "/tasks", nil), []byte("a"))
+	_, _, _ = store.ReserveIdempotencyKey(IdempotentResponse{UserID: 1, Key: "k4", RequestHash: hash,
+		LockedUntil: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}, now)
+	if w := request(http.MethodPost, "1", "k4", "a"); w.Code != http.StatusConflict || calls != 10 {
+		t.Errorf("in progress: status = %v, calls = %v, want %v, 10", w.Code, calls, http.StatusConflict)
+	}
+
+	_, _, _ = store.ReserveIdempotencyKey(IdempotentResponse{UserID: 1, Key: "k6", RequestHash: hash,
+		LockedUntil: now.Add(-time.Second), ExpiresAt: now.Add(time.Hour)}, now.Add(-time.Minute))
+	if w := request(http.MethodPost, "1", "k6", "a"); w.Code != http.StatusCreated || calls != 11 {
+		t.Errorf("stale lock: status = %v, calls = %v, want %v, 11", w.Code, calls, http.StatusCreated)
+	}
+	if w := request(http.MethodPost, "1", "k6", "a"); w.Header().Get(idempotentReplayHeader) != "true" || calls != 11 {
+		t.Errorf("stale lock retried: replayed = %v, calls = %v, want true, 11", w.Header().Get(idempotentReplayHeader), calls)
+	}
+
+	if _, reserved, _ := store.ReserveIdempotencyKey(IdempotentResponse{UserID: 1, Key: "k1"}, now.Add(2*time.Hour)); !reserved {
+		t.Error("expired key wasn't reserved again")
+	}
+}
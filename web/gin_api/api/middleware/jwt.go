@@ -3,15 +3,27 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
 	"net/http"
+	"time"
+)
+
+const (
+	accessDetailsKey = "access_details"
 )
 
 var (
 	unauthorizedMessage = gin.H{"message": "Unauthorized user, please sign in"}
+	forbiddenMessage    = gin.H{"message": "You don't have permission to perform this action"}
+	sessionMessage      = gin.H{"message": "This action requires signing in, it can't be performed with an API key"}
 )
 
 type authStore interface {
 	GetAccess(uuid string) (auth.AccessDetails, error)
+	GetAPIKey(keyHash string) (model.APIKey, error)
+	TouchAPIKey(id int, usedAt time.Time) error
+	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
 }
 
 type AuthMiddleware struct {
@@ -26,9 +38,17 @@ func NewAuthMiddleware(store authStore) *AuthMiddleware {
 
 // AuthenticateToken is an authentication middleware for gin that extracts an authorization token from the request
 // header, parses and validates it, and checks if its UUID exists on the database. If it doesn't, aborts the request
-// with a http.StatusUnauthorized status code.
+// with a http.StatusUnauthorized status code. The stored access details are kept on the gin.Context, and can be
+// retrieved with GetAccessDetails.
+// The authorization header may carry an API key instead of a jwt token, in which case it's authenticated by
+// authenticateAPIKey.
 func (am *AuthMiddleware) AuthenticateToken() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if token, err := auth.ExtractTokenFromRequest(c.Request); err == nil && auth.IsAPIKey(token) {
+			am.authenticateAPIKey(c, token)
+			return
+		}
+
 		ad, err := auth.ExtractRequestTokenMetadata(c.Request)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
@@ -39,12 +59,98 @@ func (am *AuthMiddleware) AuthenticateToken() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
 			return
 		}
-		_, err = am.Store.GetAccess(ad.AccessUUID)
+		access, err := am.Store.GetAccess(ad.AccessUUID)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
 			return
 		}
 
+		access.Role = ad.Role
+		c.Set(accessDetailsKey, access)
 		c.Next()
 	}
+}
+
+// authenticateAPIKey checks if <key> is an unexpired API key of an active user. If it isn't, aborts the request with
+// a http.StatusUnauthorized status code. Otherwise, keeps access details with the current role of the user, restricted
+// to the key scopes, on the gin.Context
+func (am *AuthMiddleware) authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, err := am.Store.GetAPIKey(auth.HashOpaqueToken(key))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
+		return
+	}
+	user, err := am.Store.GetUserBy("id", apiKey.UserID)
+	if err != nil || !user.Active || user.Pending {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
+		return
+	}
+
+	go func() /* the last usage is informative, no need to wait for it */ {
+		if tErr := am.Store.TouchAPIKey(apiKey.ID, time.Now()); tErr != nil {
+			logging.Logger.Warnln("[AUTH] Failed to record API key usage", tErr)
+		}
+	}()
+
+	scopes := make([]auth.Permission, len(apiKey.Scopes))
+	for i, scope := range apiKey.Scopes {
+		scopes[i] = auth.Permission(scope)
+	}
+	c.Set(accessDetailsKey, auth.AccessDetails{
+		UserID:   user.ID,
+		Role:     user.Role,
+		APIKeyID: apiKey.ID,
+		Scopes:   scopes,
+	})
+	c.Next()
+}
+
+// Authorize is an authorization middleware for gin that checks if the role embedded on the access token of the
+// request grants every one of the specified permissions, and if they're among the key scopes for API keys. If it
+// doesn't, aborts the request with a http.StatusForbidden status code. It must be used after AuthenticateToken.
+func Authorize(permissions ...auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, ok := GetAccessDetails(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
+			return
+		}
+		for _, permission := range permissions {
+			if !access.Allows(permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, forbiddenMessage)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession is a middleware for gin that aborts requests authenticated with an API key, rather than the jwt
+// token of a signed in session, with a http.StatusForbidden status code. It protects the account management routes,
+// so that a leaked API key can't be used to take over the account. It must be used after AuthenticateToken.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, ok := GetAccessDetails(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, unauthorizedMessage)
+			return
+		}
+		if access.APIKeyID != 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, sessionMessage)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GetAccessDetails returns the access details of the current request, resolved by AuthenticateToken
+func GetAccessDetails(c *gin.Context) (auth.AccessDetails, bool) {
+	value, exists := c.Get(accessDetailsKey)
+	if !exists {
+		return auth.AccessDetails{}, false
+	}
+	access, ok := value.(auth.AccessDetails)
+	return access, ok
 }
\ No newline at end of file
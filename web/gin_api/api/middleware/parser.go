@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 var (
@@ -17,11 +18,13 @@ type Param struct {
 	Key          string
 	ExampleValue interface{}
 	IsQuery      bool
+	// Optional parameters may be missing from the request, in which case they aren't stored on the gin.Context
+	Optional bool
 }
 
-// ExtractParam is a middleware for gin that parses parameters passed via request url path.
+// ExtractParam is a middleware for gin that parses parameters passed via request url path or query.
 // It receives n Param arguments, in order to identify the name of the parameter to extract
-// and also the type to parse to (using the example value).
+// and also the type to parse to (using the example value). Times are parsed as RFC 3339 timestamps or dates.
 // The parsed parameters are stored on the gin.Context for further usage.
 func ExtractParam(params ...Param) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -38,6 +41,9 @@ func ExtractParam(params ...Param) gin.HandlerFunc {
 				val = c.Param(param.Key)
 			}
 
+			if len(val) == 0 && param.Optional {
+				continue
+			}
 			if len(val) == 0 {
 				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, errInvalidParam(param.Key))
 				return
@@ -48,6 +54,10 @@ func ExtractParam(params ...Param) gin.HandlerFunc {
 				parsedVal, err = strconv.Atoi(val)
 			case bool:
 				parsedVal, err = strconv.ParseBool(val)
+			case time.Time:
+				parsedVal, err = parseTime(val)
+			default:
+				parsedVal = val
 			}
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, errInvalidParam(param.Key))
@@ -58,4 +68,12 @@ func ExtractParam(params ...Param) gin.HandlerFunc {
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// parseTime parses an RFC 3339 timestamp, or a date (as midnight UTC)
+func parseTime(val string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", val)
+}
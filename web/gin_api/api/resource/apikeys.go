@@ -0,0 +1,146 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"time"
+)
+
+var (
+	errAPIKeyInvalidFields = gin.H{"message": "The specified API key has invalid fields"}
+	errAPIKeyCreate        = gin.H{"message": "Couldn't create new API key"}
+	errAPIKeyGetAll        = gin.H{"message": "Couldn't get API keys"}
+	errAPIKeyRevoked       = gin.H{"message": "Successfully revoked API key"}
+	errAPIKeyInvalidExpiry = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("The expiration date must be in the future and before %v", param...)}
+	}
+	errAPIKeyScopeNotAllowed = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("Your role doesn't allow the scope %v", param...)}
+	}
+	errAPIKeyIdNotFound = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No API key with the id %v was found", param...)}
+	}
+)
+
+// apiKeyStore is used to define the database calls used by the route group define in this file
+type apiKeyStore interface {
+	CreateAPIKey(k model.APIKey) (model.APIKey, error)
+	GetUserAPIKeys(userID int) ([]model.APIKey, error)
+	DeleteUserAPIKey(userID int, id int) error
+}
+
+// APIKeyResource holds a apiKeyStore interface, used to communicate with the database
+type APIKeyResource struct {
+	Store apiKeyStore
+}
+
+// NewAPIKeyResource initializes the APIKeyResource with an existing AuthStore
+func NewAPIKeyResource(store apiKeyStore) *APIKeyResource {
+	return &APIKeyResource{
+		Store: store,
+	}
+}
+
+// MountAPIKeyRoutesTo defines new routes regarding the API keys of the current user on an existing gin.RouterGroup
+// or gin.Engine. API keys can only be managed from a signed in session, not with another API key
+func (kr *APIKeyResource) MountAPIKeyRoutesTo(r gin.IRouter) {
+	idParam := middleware.Param{Key: "id", ExampleValue: -1}
+
+	rg := r.Group("/me/api-keys", middleware.RequireSession()); {
+		rg.GET("", kr.handleGetAPIKeys)
+		rg.POST("", kr.handleCreateAPIKey)
+		rg.DELETE("/:id", middleware.ExtractParam(idParam), kr.handleRevokeAPIKey)
+	}
+}
+
+// handleCreateAPIKey validates the API key details sent on the request body and creates a new key for the current
+// user. Its scopes must be allowed by the user role, and it must expire within API_KEY_MAX_TTL. The key itself is
+// only returned this time, only its hash is stored
+func (kr *APIKeyResource) handleCreateAPIKey(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var body model.APIKeyRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAPIKeyInvalidFields)
+		return
+	}
+	for _, scope := range body.Scopes {
+		if !auth.HasPermission(access.Role, auth.Permission(scope)) {
+			c.JSON(http.StatusForbidden, errAPIKeyScopeNotAllowed(scope))
+			return
+		}
+	}
+
+	now := time.Now()
+	maxExpiresAt := now.Add(viper.GetDuration("API_KEY_MAX_TTL"))
+	expiresAt := now.Add(viper.GetDuration("API_KEY_DEFAULT_TTL"))
+	if body.ExpiresAt != nil {
+		expiresAt = *body.ExpiresAt
+	}
+	if !expiresAt.After(now) || expiresAt.After(maxExpiresAt) {
+		c.JSON(http.StatusUnprocessableEntity, errAPIKeyInvalidExpiry(maxExpiresAt.Format(time.RFC3339)))
+		return
+	}
+
+	key, hash, hint, err := auth.GenerateAPIKey()
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate API key", err)
+		c.JSON(http.StatusUnprocessableEntity, errAPIKeyCreate)
+		return
+	}
+	apiKey, err := kr.Store.CreateAPIKey(model.APIKey{
+		UserID:    access.UserID,
+		Name:      body.Name,
+		Hint:      hint,
+		KeyHash:   hash,
+		Scopes:    body.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAPIKeyCreate)
+		return
+	}
+
+	apiKey.Key = key
+	c.JSON(http.StatusCreated, apiKey)
+}
+
+// handleGetAPIKeys returns all the API keys of the current user, without the keys themselves
+func (kr *APIKeyResource) handleGetAPIKeys(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	keys, err := kr.Store.GetUserAPIKeys(access.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAPIKeyGetAll)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// handleRevokeAPIKey deletes the API key of the current user with the <id> passed on the request url path, so it
+// can't be used anymore
+func (kr *APIKeyResource) handleRevokeAPIKey(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	if err := kr.Store.DeleteUserAPIKey(access.UserID, id); err != nil {
+		c.JSON(http.StatusNotFound, errAPIKeyIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, errAPIKeyRevoked)
+}
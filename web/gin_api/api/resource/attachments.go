@@ -0,0 +1,340 @@
+package resource
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/blob"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// multipartOverhead is the size allowed for the rest of an upload request body, besides the attachment content
+const multipartOverhead = 1 << 20
+
+var (
+	errAttachmentCreate   = gin.H{"message": "Couldn't store attachment"}
+	errAttachmentInvalid  = gin.H{"message": "The attachment must be uploaded as the file field of a multipart form"}
+	errAttachmentEmpty    = gin.H{"message": "The attachment must not be empty"}
+	errAttachmentTooLarge = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("The attachment must not be larger than %v bytes", param...)}
+	}
+	errAttachmentType = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("Attachments of type %v aren't allowed", param...)}
+	}
+	errAttachmentIdNotFound = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No attachment with the id %v was found", param...)}
+	}
+
+	// errContentTooLarge is returned by sizeLimitedReader when the content is larger than allowed
+	errContentTooLarge = errors.New("content too large")
+)
+
+// attachmentStore is used to define the database calls used by the route group define in this file
+type attachmentStore interface {
+	taskAccessStore
+	CreateAttachment(attachment model.Attachment) (model.Attachment, error)
+	GetAttachments(taskID int) ([]model.Attachment, error)
+	GetAttachment(taskID int, id int) (model.Attachment, error)
+	DeleteAttachment(taskID int, id int) error
+}
+
+// AttachmentResource holds an attachmentStore interface, used to communicate with the database, and the
+// blob.BlobStore that keeps the content of the attachments
+type AttachmentResource struct {
+	Store attachmentStore
+	Blobs blob.BlobStore
+}
+
+// NewAttachmentResource initializes the AttachmentResource with an existing TaskStore and blob.BlobStore
+func NewAttachmentResource(store attachmentStore, blobs blob.BlobStore) *AttachmentResource {
+	return &AttachmentResource{
+		Store: store,
+		Blobs: blobs,
+	}
+}
+
+// MountAttachmentRoutesTo defines new routes regarding task Attachments on an existing gin.RouterGroup or gin.Engine.
+// Every user that can read a task can download its attachments, and those that can write it can upload and delete them
+func (ar *AttachmentResource) MountAttachmentRoutesTo(r gin.IRouter) {
+	var (
+		idParam           = middleware.Param{Key: "id", ExampleValue: -1}
+		attachmentIdParam = middleware.Param{Key: "attachment_id", ExampleValue: -1}
+		canRead           = middleware.Authorize(auth.PermissionReadTasks)
+		canWrite          = middleware.Authorize(auth.PermissionWriteTasks)
+	)
+
+	rg := r.Group("/tasks/:id/attachments", middleware.ExtractParam(idParam)); {
+		rg.GET("", canRead, ar.handleGetAttachments)
+		rg.POST("", canWrite, ar.handleUploadAttachment)
+		withId := rg.Group("", middleware.ExtractParam(attachmentIdParam)); {
+			withId.GET("/:attachment_id", canRead, ar.handleDownloadAttachment)
+			withId.DELETE("/:attachment_id", canWrite, ar.handleDeleteAttachment)
+		}
+	}
+}
+
+// handleUploadAttachment stores the file sent on the <file> field of the multipart request body and attaches it to the
+// task with the <id> passed on the request url path. The file is streamed to the blob store, and its type is sniffed
+// from its content, ignoring the declared one. Files larger than ATTACHMENT_MAX_SIZE, or whose type isn't one of
+// ATTACHMENT_TYPES, are rejected
+func (ar *AttachmentResource) handleUploadAttachment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID := c.GetInt("id")
+	if _, ok := checkTaskWritable(c, ar.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	maxSize := viper.GetInt64("ATTACHMENT_MAX_SIZE")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	part, ok := findFilePart(c, "file")
+	if !ok {
+		return
+	}
+	defer part.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		c.JSON(http.StatusUnprocessableEntity, errAttachmentInvalid)
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusUnprocessableEntity, errAttachmentEmpty)
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	if !allowedAttachmentType(contentType) {
+		c.JSON(http.StatusUnsupportedMediaType, errAttachmentType(contentType))
+		return
+	}
+
+	key, err := blob.NewKey()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errAttachmentCreate)
+		return
+	}
+	content := &sizeLimitedReader{Reader: io.MultiReader(bytes.NewReader(head[:n]), part), Max: maxSize}
+	if err := ar.Blobs.Put(key, content); err != nil {
+		switch {
+		case errors.Is(err, errContentTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, errAttachmentTooLarge(maxSize))
+		case content.ReadErr != nil:
+			c.JSON(http.StatusUnprocessableEntity, errAttachmentInvalid)
+		default:
+			logging.Logger.WithFields(logrus.Fields{
+				"task_id": taskID,
+				"error": err,
+			}).Errorln("[API] Failed to store attachment content")
+			c.JSON(http.StatusBadRequest, errAttachmentCreate)
+		}
+		return
+	}
+
+	attachment, err := ar.Store.CreateAttachment(model.Attachment{
+		TaskID:      taskID,
+		UserID:      access.UserID,
+		Name:        attachmentName(part.FileName()),
+		ContentType: contentType,
+		Size:        content.Size,
+		BlobKey:     key,
+	})
+	if err != nil {
+		deleteAttachmentBlobs(ar.Blobs, model.Attachment{BlobKey: key})
+		c.JSON(http.StatusBadRequest, errAttachmentCreate)
+		return
+	}
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// handleGetAttachments returns all the attachments of the task with the <id> passed on the request url path, without
+// their content
+func (ar *AttachmentResource) handleGetAttachments(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID := c.GetInt("id")
+	if _, ok := checkTaskReadable(c, ar.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	attachments, err := ar.Store.GetAttachments(taskID)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get attachments", err)
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// handleDownloadAttachment streams the content of the attachment with the <attachment_id> passed on the request url
+// path, of the task with the <id> also passed on it. Supports range and conditional requests
+func (ar *AttachmentResource) handleDownloadAttachment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID, id := c.GetInt("id"), c.GetInt("attachment_id")
+	if _, ok := checkTaskReadable(c, ar.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	attachment, err := ar.Store.GetAttachment(taskID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errAttachmentIdNotFound(id))
+		return
+	}
+	object, err := ar.Blobs.Get(attachment.BlobKey)
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"attachment_id": id,
+			"error": err,
+		}).Errorln("[API] Failed to open attachment content")
+		c.JSON(http.StatusNotFound, errAttachmentIdNotFound(id))
+		return
+	}
+	defer object.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})
+	if len(disposition) == 0 {
+		disposition = "attachment"
+	}
+	c.Header("Content-Type", attachment.ContentType)
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, attachment.Name, attachment.CreatedAt, object)
+}
+
+// handleDeleteAttachment deletes the attachment with the <attachment_id> passed on the request url path, of the task
+// with the <id> also passed on it, along with its content
+func (ar *AttachmentResource) handleDeleteAttachment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID, id := c.GetInt("id"), c.GetInt("attachment_id")
+	if _, ok := checkTaskWritable(c, ar.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	attachment, err := ar.Store.GetAttachment(taskID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errAttachmentIdNotFound(id))
+		return
+	}
+	if err := ar.Store.DeleteAttachment(taskID, id); err != nil {
+		c.JSON(http.StatusNotFound, errAttachmentIdNotFound(id))
+		return
+	}
+	deleteAttachmentBlobs(ar.Blobs, attachment)
+	c.JSON(http.StatusNoContent, "")
+}
+
+// findFilePart returns the first file on the <field> field of the multipart request body, skipping the other fields.
+// If there is none, it responds with a http.StatusUnprocessableEntity status code and returns false
+func findFilePart(c *gin.Context, field string) (*multipart.Part, bool) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAttachmentInvalid)
+		return nil, false
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, errAttachmentInvalid)
+			return nil, false
+		}
+		if part.FormName() == field && len(part.FileName()) > 0 {
+			return part, true
+		}
+		part.Close()
+	}
+}
+
+// allowedAttachmentType checks if a content type is one of ATTACHMENT_TYPES, a comma separated list of media types
+// that can end with a wildcard subtype (e.g. image/*). Parameters, such as the charset, are ignored
+func allowedAttachmentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range strings.Split(viper.GetString("ATTACHMENT_TYPES"), ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentName returns the base name of an uploaded file, without control characters and at most 255 bytes long,
+// or "attachment" if it's empty
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if len(name) == 0 || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// deleteAttachmentBlobs deletes the content of the specified attachments from the blob store. Failures are only
+// logged, since the attachments were already deleted from the database
+func deleteAttachmentBlobs(blobs blob.BlobStore, attachments ...model.Attachment) {
+	for _, attachment := range attachments {
+		if err := blobs.Delete(attachment.BlobKey); err != nil {
+			logging.Logger.WithFields(logrus.Fields{
+				"attachment_id": attachment.ID,
+				"error": err,
+			}).Errorln("[API] Failed to delete attachment content")
+		}
+	}
+}
+
+// sizeLimitedReader reads from Reader, counting the bytes read on Size, and fails with errContentTooLarge once more
+// than Max bytes are read. Errors from Reader are kept on ReadErr
+type sizeLimitedReader struct {
+	Reader  io.Reader
+	Max     int64
+	Size    int64
+	ReadErr error
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.Size += int64(n)
+	if r.Size > r.Max {
+		return n, errContentTooLarge
+	}
+	if err != nil && err != io.EOF {
+		r.ReadErr = err
+	}
+	return n, err
+}
@@ -1,11 +1,17 @@
 package resource
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
 	"github.com/jomifepe/gin_api/logging"
 	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"math"
 	"net/http"
+	"strconv"
 )
 
 var (
@@ -13,37 +19,82 @@ var (
 	errAuthInvalidLoginDetails = gin.H{"message": "Please provide valid login details"}
 	errAuthLoginFailed         = gin.H{"message": "Failed to sign in user"}
 	errAuthLogoutSuccess       = gin.H{"message": "Successfully logged out"}
+	errAuthInvalidRefreshToken = gin.H{"message": "Invalid refresh token, please sign in"}
+	errAuthRefreshFailed       = gin.H{"message": "Failed to refresh access token"}
+	errAuthPendingUser         = gin.H{"message": "Please verify your email address before signing in"}
+	errAuthInactiveUser        = gin.H{"message": "This account was deactivated"}
+	errAuthTooManyAttempts     = gin.H{"message": "Too many failed sign in attempts, please try again later"}
+	errAuthInvalidMFAToken     = gin.H{"message": "Invalid or expired two-factor token, please sign in"}
+	errAuthInvalidCode         = gin.H{"message": "Invalid two-factor authentication code"}
+	errAuthUnlockSuccess       = gin.H{"message": "Successfully unlocked user sign in"}
+	errAuthUnlockFailed        = gin.H{"message": "Couldn't unlock user sign in"}
+	errAuthUserIdNotFound      = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No user with the id %v was found", param...)}
+	}
+	errAuthLogoutAllSuccess    = gin.H{"message": "Successfully logged out of all sessions"}
+	errAuthSessionsFailed      = gin.H{"message": "Couldn't get user sessions"}
+	errAuthSessionRevoked      = gin.H{"message": "Successfully revoked session"}
+	errAuthSessionNotFound     = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No session with the uuid %v was found", param...)}
+	}
 )
 
 // authStore is used to define the database calls used by the route group define in this file
 type authStore interface {
+	secondFactorStore
 	RegisterAccess(accessDetails auth.AccessDetails) error
 	GetAccess(uuid string) (auth.AccessDetails, error)
 	DeleteAccess(accessDetails auth.AccessDetails) error
+	RotateAccess(refreshUUID string, next auth.AccessDetails) error
+	DeleteAccessFamily(familyUUID string) error
+	GetUserAccesses(userID int) ([]auth.AccessDetails, error)
+	DeleteUserAccessFamily(userID int, familyUUID string) error
+	DeleteUserAccesses(userID int, keepFamilyUUIDs ...string) error
 	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
 }
 
-// AuthResource holds a AuthStore interface, used to communicate with the database
+// AuthResource holds a AuthStore interface, used to communicate with the database, and the auth.LoginGuard that
+// protects the sign in against brute-force attacks
 type AuthResource struct {
 	Store authStore
+	Guard *auth.LoginGuard
 }
 
-// NewAuthResource initializes the AuthResource with an existing AuthStore
-func NewAuthResource(store authStore) *AuthResource {
+// NewAuthResource initializes the AuthResource with an existing AuthStore and auth.LoginGuard
+func NewAuthResource(store authStore, guard *auth.LoginGuard) *AuthResource {
 	return &AuthResource{
 		Store: store,
+		Guard: guard,
 	}
 }
 
 // MountTaskRoutesTo defines new routes regarding Authentication on an existing gin.RouterGroup or gin.Engine
 func (ar *AuthResource) MountAuthRoutesTo(r gin.IRouter, authMiddleware gin.HandlerFunc) {
+	var (
+		idParam   = middleware.Param{Key: "id", ExampleValue: -1}
+		uuidParam = middleware.Param{Key: "uuid", ExampleValue: ""}
+	)
+
 	r.POST("/login", ar.handleSignIn)
+	r.POST("/login/2fa", ar.handleSecondFactor)
 	r.POST("/logout", authMiddleware, ar.handleSignOut)
+	r.POST("/logout/all", authMiddleware, middleware.RequireSession(), ar.handleSignOutEverywhere)
+	r.POST("/refresh", ar.handleRefresh)
+	r.GET("/.well-known/jwks.json", ar.handleJWKS)
+	rg := r.Group("/me/sessions", authMiddleware, middleware.RequireSession()); {
+		rg.GET("", ar.handleGetSessions)
+		rg.DELETE("/:uuid", middleware.ExtractParam(uuidParam), ar.handleRevokeSession)
+	}
+	r.POST("/users/:id/unlock", authMiddleware, middleware.Authorize(auth.PermissionWriteUsers),
+		middleware.ExtractParam(idParam), ar.handleUnlockUser)
 }
 
 // handleSignIn handles user login requests. It validates the email and password passed on the request body,
-// checks if it matches and existing user on the database, generates a new access token, registers it on the database
-// and returns it to the user
+// checks if it matches and existing user on the database, generates a new access and refresh token pair, registers it
+// on the database and returns it to the user. Failed attempts are tracked per account and IP address, and once they
+// pile up, further attempts are delayed and eventually locked out (see auth.LoginGuard).
+// If the user has two-factor authentication enabled, a short-lived token is returned instead, that must be sent to
+// handleSecondFactor along with a TOTP or recovery code to complete the sign in
 func (ar *AuthResource) handleSignIn(c *gin.Context) {
 	var u model.AuthUser
 
@@ -53,38 +104,179 @@ func (ar *AuthResource) handleSignIn(c *gin.Context) {
 		return
 	}
 
+	if !ar.checkSignInAttempts(c, u.Email) {
+		return
+	}
+
 	dbUser, err := ar.Store.GetUserBy("email", u.Email, "")
 	if err != nil {
 		logging.Logger.Errorln("[API] Failed to get user by email from the DB", err)
+		ar.registerSignInFailure(c, u.Email)
 		c.JSON(http.StatusUnauthorized, errAuthInvalidLoginDetails)
 		return
 	}
 
 	if err = auth.ComparePasswords(u.Password, dbUser.Password); err != nil {
-		logging.Logger.Errorln("[API] Received password does not match", u.Password)
+		logging.Logger.Warnln("[API] Received password does not match")
+		ar.registerSignInFailure(c, u.Email)
 		c.JSON(http.StatusUnauthorized, errAuthInvalidLoginDetails)
 		return
 	}
 
-	tokenDetails, err := auth.GenerateToken(dbUser.ID, dbUser.Email)
+	if dbUser.TOTPEnabled {
+		mfaToken, err := auth.GenerateActionToken(auth.PurposeSecondFactor, dbUser.ID, dbUser.Email,
+			viper.GetDuration("MFA_TOKEN_TTL"))
+		if err != nil {
+			logging.Logger.Errorln("[API] Failed to generate two-factor token", err)
+			c.JSON(http.StatusUnprocessableEntity, errAuthLoginFailed)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
+	ar.signIn(c, dbUser, u.Device)
+}
+
+// handleSecondFactor completes the sign in of users with two-factor authentication enabled. It validates the token
+// returned by handleSignIn and the TOTP or recovery code passed on the request body, and returns a new access and
+// refresh token pair. Wrong codes count as failed sign in attempts
+func (ar *AuthResource) handleSecondFactor(c *gin.Context) {
+	var body model.SecondFactorRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAuthInvalidLoginDetails)
+		return
+	}
+
+	id, email, err := auth.VerifyActionToken(body.MFAToken, auth.PurposeSecondFactor)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errAuthInvalidMFAToken)
+		return
+	}
+
+	if !ar.checkSignInAttempts(c, email) {
+		return
+	}
+
+	dbUser, err := ar.Store.GetUserBy("id", id, "")
+	if err != nil || dbUser.Email != email || !dbUser.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, errAuthInvalidMFAToken)
+		return
+	}
+
+	if !verifySecondFactor(ar.Store, dbUser, body.Code) {
+		logging.Logger.Warnln("[API] Received two-factor code does not match")
+		ar.registerSignInFailure(c, email)
+		c.JSON(http.StatusUnauthorized, errAuthInvalidCode)
+		return
+	}
+
+	ar.signIn(c, dbUser, body.Device)
+}
+
+// checkSignInAttempts checks if a sign in attempt on the account with the specified email is allowed from the IP
+// address of the request. If it isn't, it responds with a http.StatusTooManyRequests status code and returns false
+func (ar *AuthResource) checkSignInAttempts(c *gin.Context, email string) bool {
+	if wait, err := ar.Guard.Check(email, c.ClientIP()); err != nil {
+		logging.Logger.Errorln("[API] Failed to check sign in attempts", err)
+	} else if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, errAuthTooManyAttempts)
+		return false
+	}
+	return true
+}
+
+// signIn completes the sign in of an user whose credentials were verified. It clears its failed sign in attempts,
+// generates a new access and refresh token pair, registers it on the database and returns it
+func (ar *AuthResource) signIn(c *gin.Context, dbUser model.User, device string) {
+	if err := ar.Guard.RegisterSuccess(dbUser.Email); err != nil {
+		logging.Logger.Errorln("[API] Failed to reset sign in attempts", err)
+	}
+
+	if dbUser.Pending {
+		c.JSON(http.StatusForbidden, errAuthPendingUser)
+		return
+	}
+	if !dbUser.Active {
+		c.JSON(http.StatusForbidden, errAuthInactiveUser)
+		return
+	}
+
+	tokens, err := auth.GenerateTokens(dbUser.ID, dbUser.Email, dbUser.Role, "")
 	if err != nil {
 		logging.Logger.Errorln("[API] Failed to generate token", err)
 		c.JSON(http.StatusUnprocessableEntity, errAuthLoginFailed)
 		return
 	}
 
-	accessDetails := auth.AccessDetails{
-		UserID:      dbUser.ID,
-		AccessUUID:  tokenDetails.UUID,
-		AccessToken: tokenDetails.Token,
-	}
+	accessDetails := tokens.AccessDetails(dbUser.ID)
+	accessDetails.Device = device
+	accessDetails.UserAgent = c.Request.UserAgent()
+	accessDetails.IPAddress = c.ClientIP()
 	if tErr := ar.Store.RegisterAccess(accessDetails); tErr != nil {
 		logging.Logger.Errorln("[API] Failed to store token", tErr)
 		c.JSON(http.StatusUnprocessableEntity, errAuthLoginFailed)
 		return
 	}
 
-	c.JSON(http.StatusOK, tokenDetails)
+	c.JSON(http.StatusOK, tokens)
+}
+
+// registerSignInFailure registers a failed sign in attempt on the account with the specified email, from the IP
+// address of the request
+func (ar *AuthResource) registerSignInFailure(c *gin.Context, email string) {
+	if err := ar.Guard.RegisterFailure(email, c.ClientIP()); err != nil {
+		logging.Logger.Errorln("[API] Failed to register failed sign in attempt", err)
+	}
+}
+
+// handleRefresh handles access token renewal requests. It validates the refresh token passed on the request body,
+// rotates it by replacing its access entry with a new token pair from the same family, and returns the new pair.
+// If the refresh token was already used, it's assumed to be stolen and the whole token family is revoked.
+func (ar *AuthResource) handleRefresh(c *gin.Context) {
+	var body model.RefreshRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAuthInvalidRefreshToken)
+		return
+	}
+
+	refreshDetails, err := auth.ExtractRefreshTokenMetadata(body.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errAuthInvalidRefreshToken)
+		return
+	}
+
+	dbUser, err := ar.Store.GetUserBy("id", refreshDetails.UserID)
+	if err != nil || !dbUser.Active {
+		c.JSON(http.StatusUnauthorized, errAuthInvalidRefreshToken)
+		return
+	}
+
+	tokens, err := auth.GenerateTokens(dbUser.ID, dbUser.Email, dbUser.Role, refreshDetails.FamilyUUID)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate token", err)
+		c.JSON(http.StatusUnprocessableEntity, errAuthRefreshFailed)
+		return
+	}
+
+	accessDetails := tokens.AccessDetails(dbUser.ID)
+	accessDetails.UserAgent = c.Request.UserAgent()
+	accessDetails.IPAddress = c.ClientIP()
+	if err = ar.Store.RotateAccess(refreshDetails.RefreshUUID, accessDetails); err != nil {
+		if errors.Is(err, auth.ErrTokenReused) {
+			logging.Logger.Warnln("[API] Refresh token reuse detected, revoking token family", refreshDetails.FamilyUUID)
+			if fErr := ar.Store.DeleteAccessFamily(refreshDetails.FamilyUUID); fErr != nil {
+				logging.Logger.Errorln("[API] Failed to revoke token family", fErr)
+			}
+			c.JSON(http.StatusUnauthorized, errAuthInvalidRefreshToken)
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, errAuthRefreshFailed)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
 }
 
 // handleSignOut handles user logout requests. It reads the authorization bearer token passed on the request
@@ -101,3 +293,81 @@ func (ar *AuthResource) handleSignOut(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, errAuthLogoutSuccess)
 }
+
+// handleSignOutEverywhere handles requests to sign out of every session. It deletes all the access records of the
+// user that owns the authorization bearer token passed on the request
+func (ar *AuthResource) handleSignOutEverywhere(c *gin.Context) {
+	accessDetails, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	if err := ar.Store.DeleteUserAccesses(accessDetails.UserID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAuthUnauthorizedUser)
+		return
+	}
+	c.JSON(http.StatusOK, errAuthLogoutAllSuccess)
+}
+
+// handleGetSessions returns the active sessions of the current user, flagging the one used on the request
+func (ar *AuthResource) handleGetSessions(c *gin.Context) {
+	accessDetails, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	accesses, err := ar.Store.GetUserAccesses(accessDetails.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAuthSessionsFailed)
+		return
+	}
+
+	sessions := make([]auth.Session, 0, len(accesses))
+	for _, access := range accesses {
+		session := access.Session()
+		session.Current = access.FamilyUUID == accessDetails.FamilyUUID
+		sessions = append(sessions, session)
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// handleRevokeSession signs the current user out of the session with the <uuid> passed on the request url path
+func (ar *AuthResource) handleRevokeSession(c *gin.Context) {
+	accessDetails, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	sessionUUID := c.GetString("uuid")
+	if err := ar.Store.DeleteUserAccessFamily(accessDetails.UserID, sessionUUID); err != nil {
+		c.JSON(http.StatusNotFound, errAuthSessionNotFound(sessionUUID))
+		return
+	}
+	c.JSON(http.StatusOK, errAuthSessionRevoked)
+}
+
+// handleUnlockUser clears the failed sign in attempts and lockout of the user with the <id> passed on the request
+// url path
+func (ar *AuthResource) handleUnlockUser(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	user, err := ar.Store.GetUserBy("id", id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errAuthUserIdNotFound(id))
+		return
+	}
+	if err = ar.Guard.Unlock(user.Email, access.UserID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errAuthUnlockFailed)
+		return
+	}
+	c.JSON(http.StatusOK, errAuthUnlockSuccess)
+}
+
+// handleJWKS returns the public keys that can be used to verify the tokens issued by the API, as a JSON Web Key Set
+func (ar *AuthResource) handleJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.JWKS())
+}
@@ -0,0 +1,219 @@
+package resource
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jomifepe/gin_api/model"
+	"net/http"
+)
+
+var (
+	errBulkInvalid   = gin.H{"message": "The bulk request must have between 1 and 500 operations"}
+	errBulkAborted   = gin.H{"message": "The operation wasn't applied, since another operation of the bulk failed"}
+	errBulkDuplicate = gin.H{"message": "Each task can only be on one operation of the bulk"}
+)
+
+// bulkResult is the result of one of the operations of a bulk request: the status code its single request would
+// respond with, along with the resulting task or the error
+type bulkResult struct {
+	Status int         `json:"status"`
+	Task   *model.Task `json:"task,omitempty"`
+	Error  gin.H       `json:"error,omitempty"`
+}
+
+// handleBulkTasks applies the create, update, toggle and delete operations on the request body (see model.TaskBulk)
+// on a single transaction, unless best_effort is set, in which case each one is applied independently. The tasks
+// are created first, and then the other operations are applied in order. Responds with the result of each operation,
+// in the same order (see bulkResult), with a http.StatusOK status code if all of them succeeded, or otherwise with a
+// http.StatusMultiStatus status code on best effort, or a http.StatusUnprocessableEntity status code if the
+// transaction was rolled back
+func (tr *TaskResource) handleBulkTasks(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var bulk model.TaskBulk
+	if err := c.ShouldBindJSON(&bulk); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errBulkInvalid)
+		return
+	}
+
+	results := make([]bulkResult, len(bulk.Operations))
+	tr.checkTaskOperations(bulk.Operations, access.UserID, results)
+	var (
+		ops     []model.TaskOperation
+		indexes []int
+	)
+	for i, op := range bulk.Operations {
+		if results[i].Status != 0 {
+			if !bulk.BestEffort {
+				respondBulk(c, results, false)
+				return
+			}
+			continue
+		}
+		ops, indexes = append(ops, op), append(indexes, i)
+	}
+
+	var ids []int
+	for j, err := range tr.Store.ApplyTaskOperations(access.UserID, ops, !bulk.BestEffort) {
+		if err != nil {
+			results[indexes[j]] = taskOperationError(ops[j], err)
+		} else if ops[j].Op == model.TaskActionCreate {
+			ids = append(ids, ops[j].Task.ID)
+		} else if ops[j].Op != model.TaskActionDelete {
+			ids = append(ids, ops[j].ID)
+		}
+	}
+	tasks, _ := tr.Store.GetTasksByID(ids, access.UserID)
+	updated := make(map[int]model.Task, len(tasks))
+	for _, t := range tasks {
+		updated[t.ID] = t
+	}
+	for j, op := range ops {
+		i := indexes[j]
+		if results[i].Status != 0 {
+			continue
+		}
+		switch op.Op {
+		case model.TaskActionCreate:
+			t := updated[op.Task.ID]
+			results[i] = bulkResult{Status: http.StatusCreated, Task: &t}
+		case model.TaskActionUpdate, model.TaskActionToggle:
+			t := updated[op.ID]
+			results[i] = bulkResult{Status: http.StatusOK, Task: &t}
+		case model.TaskActionDelete:
+			results[i] = bulkResult{Status: http.StatusNoContent}
+		}
+	}
+
+	respondBulk(c, results, bulk.BestEffort)
+}
+
+// checkTaskOperations checks if the operations of a bulk request of the user with the id <userID> can be applied,
+// like their single requests do, setting the results of the ones that can't on <results>, with the same status
+// code and body. Sets the id and user of the tasks to create
+func (tr *TaskResource) checkTaskOperations(ops []model.TaskOperation, userID int, results []bulkResult) {
+	var ids, tagIDs []int
+	for _, op := range ops {
+		if op.Op != model.TaskActionCreate {
+			ids = append(ids, op.ID)
+		}
+		if op.Task != nil {
+			tagIDs = append(tagIDs, op.Task.TagIDs...)
+		}
+	}
+	current := map[int]model.Task{}
+	if tasks, err := tr.Store.GetTasksByID(ids, userID); err == nil {
+		for _, t := range tasks {
+			current[t.ID] = t
+		}
+	}
+	var tags []model.Tag
+	if len(tagIDs) > 0 {
+		tags, _ = tr.Store.GetTagsByID(userID, tagIDs)
+	}
+
+	roles, seen := map[int]string{}, map[int]bool{}
+	fail := func(i int, status int, body gin.H) {
+		results[i] = bulkResult{Status: status, Error: body}
+	}
+	for i, op := range ops {
+		var projectID *int
+		if err := binding.Validator.ValidateStruct(op); err != nil {
+			fail(i, http.StatusUnprocessableEntity, errTaskInvalidFields)
+			continue
+		}
+		if op.Op == model.TaskActionCreate || op.Op == model.TaskActionUpdate {
+			if op.Task == nil {
+				fail(i, http.StatusUnprocessableEntity, errTaskInvalidFields)
+				continue
+			}
+			if status, body := taskTagsError(tags, op.Task.TagIDs); status != 0 {
+				fail(i, status, body)
+				continue
+			}
+			if status, body := taskRecurrenceError(*op.Task); status != 0 {
+				fail(i, status, body)
+				continue
+			}
+		}
+		if op.Op == model.TaskActionCreate {
+			op.Task.ID, op.Task.UserID, op.Task.RecurredFromID = 0, userID, nil
+			projectID = op.Task.ProjectID
+		} else {
+			t, ok := current[op.ID]
+			if !ok {
+				fail(i, http.StatusNotFound, errTaskIdNotFound(op.ID))
+				continue
+			}
+			if seen[op.ID] {
+				fail(i, http.StatusUnprocessableEntity, errBulkDuplicate)
+				continue
+			}
+			seen[op.ID] = true
+			if op.Version != 0 && op.Version != t.Version {
+				fail(i, http.StatusPreconditionFailed, errPreconditionFailed)
+				continue
+			}
+			projectID = t.ProjectID
+		}
+		if projectID == nil {
+			continue
+		}
+		role, ok := roles[*projectID]
+		if !ok {
+			role, _ = tr.Store.GetProjectRole(*projectID, userID)
+			roles[*projectID] = role
+		}
+		if status, body := projectRoleError(*projectID, role, model.ProjectRoleEditor); status != 0 {
+			fail(i, status, body)
+		}
+	}
+}
+
+// taskOperationError returns the result of an operation of a bulk request that failed with <err>, with the status
+// code and body its single request would respond with, or a http.StatusFailedDependency status code if it wasn't
+// applied because another operation failed
+func taskOperationError(op model.TaskOperation, err error) bulkResult {
+	if errors.Is(err, model.ErrBulkAborted) {
+		return bulkResult{Status: http.StatusFailedDependency, Error: errBulkAborted}
+	}
+	if status, body := taskRuleError(err); status != 0 {
+		return bulkResult{Status: status, Error: body}
+	}
+	switch op.Op {
+	case model.TaskActionCreate:
+		return bulkResult{Status: http.StatusBadRequest, Error: errTaskCreate}
+	case model.TaskActionToggle:
+		return bulkResult{Status: http.StatusNotFound, Error: errTaskToggle}
+	case model.TaskActionDelete:
+		return bulkResult{Status: http.StatusNotFound, Error: errTaskDelete(op.ID)}
+	default:
+		return bulkResult{Status: http.StatusNotFound, Error: errTaskIdNotFound(op.ID)}
+	}
+}
+
+// respondBulk responds with the results of a bulk request, failing the operations without a result with a
+// http.StatusFailedDependency status code (see handleBulkTasks for the status code of the response)
+func respondBulk(c *gin.Context, results []bulkResult, bestEffort bool) {
+	failed := 0
+	for i := range results {
+		if results[i].Status == 0 {
+			results[i] = bulkResult{Status: http.StatusFailedDependency, Error: errBulkAborted}
+		}
+		if results[i].Status >= http.StatusBadRequest {
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		c.JSON(http.StatusOK, results)
+	case bestEffort:
+		c.JSON(http.StatusMultiStatus, results)
+	default:
+		c.JSON(http.StatusUnprocessableEntity, results)
+	}
+}
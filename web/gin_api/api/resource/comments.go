@@ -0,0 +1,209 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"net/http"
+)
+
+var (
+	errCommentCreate        = gin.H{"message": "Couldn't create new comment"}
+	errCommentInvalidFields = gin.H{"message": "The specified comment has invalid fields"}
+	errCommentNotAuthor     = gin.H{"message": "Only the author of a comment can change it"}
+	errCommentIdNotFound    = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No comment with the id %v was found", param...)}
+	}
+)
+
+// commentStore is used to define the database calls used by the route group define in this file
+type commentStore interface {
+	taskAccessStore
+	CreateTaskComment(comment model.TaskComment) (model.TaskComment, error)
+	GetTaskComments(taskID int, page model.Page) ([]model.TaskComment, int64, error)
+	GetTaskComment(taskID int, id int) (model.TaskComment, error)
+	UpdateTaskComment(comment model.TaskComment) (model.TaskComment, error)
+	DeleteTaskComment(taskID int, id int) error
+}
+
+// CommentResource holds a commentStore interface, used to communicate with the database
+type CommentResource struct {
+	Store commentStore
+}
+
+// NewCommentResource initializes the CommentResource with an existing TaskStore
+func NewCommentResource(store commentStore) *CommentResource {
+	return &CommentResource{
+		Store: store,
+	}
+}
+
+// MountCommentRoutesTo defines new routes regarding task Comments on an existing gin.RouterGroup or gin.Engine.
+// Every user that can read a task can comment on it
+func (cr *CommentResource) MountCommentRoutesTo(r gin.IRouter) {
+	var (
+		idParam        = middleware.Param{Key: "id", ExampleValue: -1}
+		commentIdParam = middleware.Param{Key: "comment_id", ExampleValue: -1}
+		canRead        = middleware.Authorize(auth.PermissionReadTasks)
+		canWrite       = middleware.Authorize(auth.PermissionWriteTasks)
+	)
+
+	rg := r.Group("/tasks/:id/comments", middleware.ExtractParam(idParam)); {
+		rg.GET("", canRead, middleware.ExtractParam(listParams()...), cr.handleGetComments)
+		rg.POST("", canWrite, cr.handleCreateComment)
+		withId := rg.Group("", middleware.ExtractParam(commentIdParam)); {
+			withId.GET("/:comment_id", canRead, cr.handleGetComment)
+			withId.PUT("/:comment_id", canWrite, cr.handleUpdateComment)
+			withId.DELETE("/:comment_id", canWrite, cr.handleDeleteComment)
+		}
+	}
+}
+
+// handleCreateComment validates the comment sent on the request body and adds it, if it's valid, to the task with the
+// <id> passed on the request url path. The comment belongs to the current user
+func (cr *CommentResource) handleCreateComment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID := c.GetInt("id")
+	if _, ok := checkTaskReadable(c, cr.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	var comment model.TaskComment
+	if err := c.ShouldBindJSON(&comment); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errCommentInvalidFields)
+		return
+	}
+	comment.ID = 0
+	comment.TaskID = taskID
+	comment.UserID = access.UserID
+
+	newComment, err := cr.Store.CreateTaskComment(comment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errCommentCreate)
+		return
+	}
+	c.JSON(http.StatusCreated, newComment)
+}
+
+// handleGetComments returns a page of the comments of the task with the <id> passed on the request url path, oldest
+// first unless another sort is specified (see extractPage for pagination and sorting)
+func (cr *CommentResource) handleGetComments(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	page, ok := extractPage(c, "id", "created_at", "updated_at")
+	if !ok {
+		return
+	}
+	taskID := c.GetInt("id")
+	if _, ok := checkTaskReadable(c, cr.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	comments, total, err := cr.Store.GetTaskComments(taskID, page)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get task comments", err)
+	}
+	setPageHeaders(c, page, total)
+	c.JSON(http.StatusOK, comments)
+}
+
+// handleGetComment returns the comment with the <comment_id> passed on the request url path, of the task with the
+// <id> also passed on it
+func (cr *CommentResource) handleGetComment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID, id := c.GetInt("id"), c.GetInt("comment_id")
+	if _, ok := checkTaskReadable(c, cr.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	comment, err := cr.Store.GetTaskComment(taskID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errCommentIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, comment)
+}
+
+// handleUpdateComment validates the comment passed on the request body, and updates it (if it's valid), using the
+// <id> and <comment_id> passed on the request url path. Only the author of a comment can update it
+func (cr *CommentResource) handleUpdateComment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID, id := c.GetInt("id"), c.GetInt("comment_id")
+	if _, ok := checkTaskReadable(c, cr.Store, taskID, access.UserID); !ok {
+		return
+	}
+
+	var comment model.TaskComment
+	if err := c.ShouldBindJSON(&comment); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errCommentInvalidFields)
+		return
+	}
+	existing, err := cr.Store.GetTaskComment(taskID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errCommentIdNotFound(id))
+		return
+	}
+	if existing.UserID != access.UserID {
+		c.JSON(http.StatusForbidden, errCommentNotAuthor)
+		return
+	}
+
+	comment.ID = id
+	comment.TaskID = taskID
+	comment.UserID = access.UserID
+	updatedComment, err := cr.Store.UpdateTaskComment(comment)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errCommentIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, updatedComment)
+}
+
+// handleDeleteComment deletes the comment with the <comment_id> passed on the request url path, of the task with the
+// <id> also passed on it. Comments can be deleted by their author, and on project tasks, by the project owners
+func (cr *CommentResource) handleDeleteComment(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	taskID, id := c.GetInt("id"), c.GetInt("comment_id")
+	t, ok := checkTaskReadable(c, cr.Store, taskID, access.UserID)
+	if !ok {
+		return
+	}
+
+	comment, err := cr.Store.GetTaskComment(taskID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errCommentIdNotFound(id))
+		return
+	}
+	if comment.UserID != access.UserID {
+		if t.ProjectID == nil {
+			c.JSON(http.StatusForbidden, errCommentNotAuthor)
+			return
+		}
+		if _, ok := checkProjectRole(c, cr.Store, *t.ProjectID, access.UserID, model.ProjectRoleOwner); !ok {
+			return
+		}
+	}
+
+	if err := cr.Store.DeleteTaskComment(taskID, id); err != nil {
+		c.JSON(http.StatusNotFound, errCommentIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusNoContent, "")
+}
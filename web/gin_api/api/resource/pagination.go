@@ -0,0 +1,142 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errPageInvalidOffset = gin.H{"message": "The offset must not be negative"}
+	errPageInvalidLimit  = gin.H{"message": "The limit must be positive"}
+	errPageInvalidSort   = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("Can't sort by %v, the allowed fields are: %v", param...)}
+	}
+
+	// pageParams are the query parameters of a paginated listing, to be extracted with middleware.ExtractParam
+	pageParams = []middleware.Param{
+		{Key: "limit", ExampleValue: -1, IsQuery: true, Optional: true},
+		{Key: "offset", ExampleValue: -1, IsQuery: true, Optional: true},
+		{Key: "sort", ExampleValue: "", IsQuery: true, Optional: true},
+	}
+)
+
+// listParams returns the query parameters of a paginated listing, followed by its filter <params>
+func listParams(params ...middleware.Param) []middleware.Param {
+	return append(append([]middleware.Param{}, pageParams...), params...)
+}
+
+// extractPage returns the pagination and sorting of a listing, extracted from the query parameters of the request. The
+// limit defaults to PAGE_DEFAULT_LIMIT and is capped at PAGE_MAX_LIMIT (both at least 1), and results are sorted by id
+// unless another one of the <sortable> fields is specified (prefixed with "-" for descending order). If the parameters
+// are invalid, it responds with a http.StatusUnprocessableEntity status code and returns false
+func extractPage(c *gin.Context, sortable ...string) (model.Page, bool) {
+	page := model.Page{Limit: viper.GetInt("PAGE_DEFAULT_LIMIT"), Sort: "id"}
+
+	if limit, exists := c.Get("limit"); exists {
+		if page.Limit = limit.(int); page.Limit <= 0 {
+			c.JSON(http.StatusUnprocessableEntity, errPageInvalidLimit)
+			return model.Page{}, false
+		}
+	}
+	if maxLimit := viper.GetInt("PAGE_MAX_LIMIT"); page.Limit > maxLimit {
+		page.Limit = maxLimit
+	}
+	if page.Limit < 1 {
+		page.Limit = 1
+	}
+	if offset, exists := c.Get("offset"); exists {
+		if page.Offset = offset.(int); page.Offset < 0 {
+			c.JSON(http.StatusUnprocessableEntity, errPageInvalidOffset)
+			return model.Page{}, false
+		}
+	}
+
+	if sort := c.GetString("sort"); len(sort) > 0 {
+		page.Desc = strings.HasPrefix(sort, "-")
+		page.Sort = strings.TrimPrefix(sort, "-")
+		if !containsString(sortable, page.Sort) {
+			c.JSON(http.StatusUnprocessableEntity, errPageInvalidSort(page.Sort, strings.Join(sortable, ", ")))
+			return model.Page{}, false
+		}
+	}
+	return page, true
+}
+
+// setPageHeaders sets the pagination headers of a listing response: X-Total-Count, with the total number of
+// results, and Link, with the URLs of the first, previous, next and last pages (RFC 8288)
+func setPageHeaders(c *gin.Context, page model.Page, total int64) {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+
+	var (
+		links      []string
+		lastOffset = 0
+	)
+	if total > 0 {
+		lastOffset = int((total - 1) / int64(page.Limit)) * page.Limit
+	}
+	link := func(rel string, offset int) {
+		query := c.Request.URL.Query()
+		query.Set("limit", strconv.Itoa(page.Limit))
+		query.Set("offset", strconv.Itoa(offset))
+		u := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+		links = append(links, fmt.Sprintf(`<%v>; rel="%v"`, u.String(), rel))
+	}
+
+	link("first", 0)
+	if page.Offset > 0 {
+		prevOffset := page.Offset - page.Limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		link("prev", prevOffset)
+	}
+	if int64(page.Offset+page.Limit) < total {
+		link("next", page.Offset+page.Limit)
+	}
+	link("last", lastOffset)
+	c.Header("Link", strings.Join(links, ", "))
+}
+
+// optionalBool returns the bool parameter <key> extracted by middleware.ExtractParam, or nil if it wasn't specified
+func optionalBool(c *gin.Context, key string) *bool {
+	if value, exists := c.Get(key); exists {
+		b := value.(bool)
+		return &b
+	}
+	return nil
+}
+
+// optionalInt returns the int parameter <key> extracted by middleware.ExtractParam, or nil if it wasn't specified
+func optionalInt(c *gin.Context, key string) *int {
+	if value, exists := c.Get(key); exists {
+		i := value.(int)
+		return &i
+	}
+	return nil
+}
+
+// optionalTime returns the time parameter <key> extracted by middleware.ExtractParam, or nil if it wasn't specified
+func optionalTime(c *gin.Context, key string) *time.Time {
+	if value, exists := c.Get(key); exists {
+		t := value.(time.Time)
+		return &t
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
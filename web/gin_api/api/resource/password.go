@@ -0,0 +1,187 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/mailer"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	errPasswordInvalidFields  = gin.H{"message": "The specified password details have invalid fields"}
+	errPasswordIncorrect      = gin.H{"message": "The current password is incorrect"}
+	errPasswordChangeFailed   = gin.H{"message": "Couldn't change password"}
+	errPasswordChanged        = gin.H{"message": "Successfully changed password, other sessions were signed out"}
+	errPasswordResetSent      = gin.H{"message": "If the email belongs to an account, a password reset email was sent"}
+	errPasswordInvalidToken   = gin.H{"message": "Invalid or expired password reset token"}
+	errPasswordResetFailed    = gin.H{"message": "Couldn't reset password"}
+	errPasswordResetCompleted = gin.H{"message": "Successfully reset password, please sign in"}
+	errPasswordResetValid     = gin.H{"message": "Valid password reset token, send it along with the new password to POST /password/reset"}
+)
+
+// passwordStore is used to define the database calls used by the route group define in this file
+type passwordStore interface {
+	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
+	UpdateUserPassword(id int, passwordHash string) error
+	CreatePasswordReset(r model.PasswordReset) error
+	GetPasswordReset(tokenHash string) (model.PasswordReset, error)
+	UsePasswordReset(tokenHash string, passwordHash string) (int, error)
+	DeleteUserAccesses(userID int, keepFamilyUUIDs ...string) error
+}
+
+// PasswordResource holds a passwordStore interface, used to communicate with the database, and the mailer.Mailer
+// used to deliver password reset emails
+type PasswordResource struct {
+	Store  passwordStore
+	Mailer mailer.Mailer
+}
+
+// NewPasswordResource initializes the PasswordResource with an existing AuthStore and mailer.Mailer
+func NewPasswordResource(store passwordStore, mail mailer.Mailer) *PasswordResource {
+	return &PasswordResource{
+		Store:  store,
+		Mailer: mail,
+	}
+}
+
+// MountPasswordRoutesTo defines new routes regarding password management on an existing gin.RouterGroup or
+// gin.Engine. Only the password change requires authentication, using <authMiddleware>
+func (pr *PasswordResource) MountPasswordRoutesTo(r gin.IRouter, authMiddleware gin.HandlerFunc) {
+	tokenParam := middleware.Param{Key: "token", ExampleValue: "", IsQuery: true}
+
+	r.PUT("/me/password", authMiddleware, middleware.RequireSession(), pr.handleChangePassword)
+	r.POST("/password/forgot", pr.handleForgotPassword)
+	r.GET("/password/reset", middleware.ExtractParam(tokenParam), pr.handleCheckPasswordReset)
+	r.POST("/password/reset", pr.handleResetPassword)
+}
+
+// handleChangePassword checks the current password passed on the request body and replaces it with the new one.
+// Every other session of the user is signed out
+func (pr *PasswordResource) handleChangePassword(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var body model.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errPasswordInvalidFields)
+		return
+	}
+
+	dbUser, err := pr.Store.GetUserBy("id", access.UserID, "")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errAuthUnauthorizedUser)
+		return
+	}
+	if err = auth.ComparePasswords(body.OldPassword, dbUser.Password); err != nil {
+		c.JSON(http.StatusForbidden, errPasswordIncorrect)
+		return
+	}
+
+	hash, err := auth.GeneratePassword(body.NewPassword)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate hash from password", err)
+		c.JSON(http.StatusUnprocessableEntity, errPasswordChangeFailed)
+		return
+	}
+	if err = pr.Store.UpdateUserPassword(dbUser.ID, hash); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errPasswordChangeFailed)
+		return
+	}
+	if err = pr.Store.DeleteUserAccesses(dbUser.ID, access.FamilyUUID); err != nil {
+		logging.Logger.Errorln("[API] Failed to revoke other sessions after password change", err)
+	}
+
+	c.JSON(http.StatusOK, errPasswordChanged)
+}
+
+// handleForgotPassword issues a single-use password reset token to the user with the email passed on the request
+// body, and sends it by email. The response is the same whether the user exists or not, to avoid disclosing
+// registered emails
+func (pr *PasswordResource) handleForgotPassword(c *gin.Context) {
+	var body model.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errPasswordInvalidFields)
+		return
+	}
+
+	if user, err := pr.Store.GetUserBy("email", body.Email); err == nil {
+		if err = pr.sendPasswordReset(user); err != nil {
+			logging.Logger.Errorln("[API] Failed to send password reset", err)
+		}
+	}
+	c.JSON(http.StatusAccepted, errPasswordResetSent)
+}
+
+// handleCheckPasswordReset is the entry point of the link on the password reset email. It checks if the password
+// reset token passed on the request url query is still valid, without using it, since the new password must be sent
+// along with it to handleResetPassword
+func (pr *PasswordResource) handleCheckPasswordReset(c *gin.Context) {
+	if _, err := pr.Store.GetPasswordReset(auth.HashOpaqueToken(c.GetString("token"))); err != nil {
+		c.JSON(http.StatusUnauthorized, errPasswordInvalidToken)
+		return
+	}
+	c.JSON(http.StatusOK, errPasswordResetValid)
+}
+
+// handleResetPassword validates the password reset token passed on the request body, marks it as used, sets the new
+// password and signs the user out of every session
+func (pr *PasswordResource) handleResetPassword(c *gin.Context) {
+	var body model.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errPasswordInvalidFields)
+		return
+	}
+
+	hash, err := auth.GeneratePassword(body.NewPassword)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate hash from password", err)
+		c.JSON(http.StatusUnprocessableEntity, errPasswordResetFailed)
+		return
+	}
+
+	userID, err := pr.Store.UsePasswordReset(auth.HashOpaqueToken(body.Token), hash)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errPasswordInvalidToken)
+		return
+	}
+	if err = pr.Store.DeleteUserAccesses(userID); err != nil {
+		logging.Logger.Errorln("[API] Failed to revoke sessions after password reset", err)
+	}
+
+	c.JSON(http.StatusOK, errPasswordResetCompleted)
+}
+
+// sendPasswordReset stores a new password reset for the user, that expires after PASSWORD_RESET_TTL, and sends
+// the user an email with its token
+func (pr *PasswordResource) sendPasswordReset(user model.User) error {
+	token, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = pr.Store.CreatePasswordReset(model.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(viper.GetDuration("PASSWORD_RESET_TTL")),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%v/password/reset?token=%v", viper.GetString("PUBLIC_URL"), url.QueryEscape(token))
+	return pr.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %v,\r\n\r\nA password reset was requested for your account. "+
+			"To choose a new password, open the following link:\r\n%v\r\n\r\n"+
+			"If you didn't request it, you can ignore this email.\r\n", user.FirstName, link),
+	})
+}
@@ -0,0 +1,121 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/jomifepe/gin_api/patch"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	errPatchUnsupported = gin.H{"message": "The patch must be a JSON merge patch (" + mergePatchType +
+		") or a JSON patch (" + jsonPatchType + ")"}
+	errPatchInvalid  = gin.H{"message": "The specified patch is malformed"}
+	errPatchConflict = gin.H{"message": "The patch refers to locations that don't exist, or one of its tests failed"}
+	errPatchReadOnly = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("The field %v can't be changed", param...)}
+	}
+)
+
+// fieldValidator checks the validate tags of a struct, which gin doesn't, since it only checks the binding ones
+var fieldValidator = validator.New()
+
+// applyPatch applies the patch on the request body to the JSON representation of <current>, and decodes the result
+// into <patched>. The patch is a JSON merge patch (RFC 7396) or a JSON patch (RFC 6902), depending on the request
+// content type. Returns the names of the (top-level) fields that changed, which can only be the <writable> ones.
+// If the patch can't be applied, it responds with a http.StatusUnsupportedMediaType, http.StatusBadRequest or
+// http.StatusConflict status code, or with a http.StatusUnprocessableEntity status code (and <invalid>) if the result
+// changes other fields or can't be decoded, and returns false
+func applyPatch(c *gin.Context, current interface{}, patched interface{}, invalid gin.H, writable ...string) ([]string, bool) {
+	var apply func([]byte, []byte) ([]byte, error)
+	switch c.ContentType() {
+	case mergePatchType:
+		apply = patch.MergePatch
+	case jsonPatchType:
+		apply = patch.JSONPatch
+	default:
+		c.Header("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		c.JSON(http.StatusUnsupportedMediaType, errPatchUnsupported)
+		return nil, false
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errPatchInvalid)
+		return nil, false
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errPatchInvalid)
+		return nil, false
+	}
+	result, err := apply(doc, body)
+	if err != nil {
+		if errors.Is(err, patch.ErrConflict) {
+			c.JSON(http.StatusConflict, errPatchConflict)
+		} else {
+			c.JSON(http.StatusBadRequest, errPatchInvalid)
+		}
+		return nil, false
+	}
+
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(doc, &before); err != nil {
+		c.JSON(http.StatusBadRequest, errPatchInvalid)
+		return nil, false
+	}
+	if err := json.Unmarshal(result, &after); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, invalid)
+		return nil, false
+	}
+	changed := changedFields(before, after)
+	for _, field := range changed {
+		if !containsString(writable, field) {
+			c.JSON(http.StatusUnprocessableEntity, errPatchReadOnly(field))
+			return nil, false
+		}
+	}
+	if err := json.Unmarshal(result, patched); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, invalid)
+		return nil, false
+	}
+	return changed, true
+}
+
+// changedFields returns the sorted names of the fields whose values differ between two JSON objects. Fields that are
+// null on one of them and missing on the other are the same
+func changedFields(before map[string]interface{}, after map[string]interface{}) []string {
+	var changed []string
+	for field, value := range after {
+		if !reflect.DeepEqual(before[field], value) {
+			changed = append(changed, field)
+		}
+	}
+	for field, value := range before {
+		if _, ok := after[field]; !ok && value != nil {
+			changed = append(changed, field)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// validateFields checks a struct against both its binding tags, like gin does when binding a request body, and its
+// validate tags
+func validateFields(obj interface{}) error {
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		return err
+	}
+	return fieldValidator.Struct(obj)
+}
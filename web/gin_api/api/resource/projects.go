@@ -0,0 +1,447 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/blob"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/mailer"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	errProjectCreate             = gin.H{"message": "Couldn't create new project"}
+	errProjectInvalidFields      = gin.H{"message": "The specified project has invalid fields"}
+	errProjectForbidden          = gin.H{"message": "Your role on this project doesn't allow this action"}
+	errProjectLastOwner          = gin.H{"message": "A project must keep at least one owner"}
+	errProjectAlreadyMember      = gin.H{"message": "The invited user is already a member of the project"}
+	errProjectInvitationFailed   = gin.H{"message": "Couldn't send project invitation"}
+	errProjectInvalidInvitation  = gin.H{"message": "Invalid or expired project invitation"}
+	errProjectValidInvitation    = gin.H{"message": "Valid project invitation, sign in as the invited user and send it to POST /projects/invitations/accept"}
+	errProjectMemberNotFound     = gin.H{"message": "The specified user isn't a member of the project"}
+	errProjectInvitationNotFound = gin.H{"message": "No pending invitation with the specified id was found"}
+	errProjectIdNotFound         = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No project with the id %v was found", param...)}
+	}
+
+	// projectRoleRanks orders the project roles, each one allowing everything the lower ones allow
+	projectRoleRanks = map[string]int{
+		model.ProjectRoleViewer: 1,
+		model.ProjectRoleEditor: 2,
+		model.ProjectRoleOwner:  3,
+	}
+)
+
+// projectRoleStore is used to define the database call used to check the role of a user on a project
+type projectRoleStore interface {
+	GetProjectRole(id int, userID int) (string, error)
+}
+
+// projectStore is used to define the database calls used by the route group define in this file
+type projectStore interface {
+	projectRoleStore
+	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
+	CreateProject(p model.Project, ownerID int) (model.Project, error)
+	GetUserProjects(userID int) ([]model.Project, error)
+	GetProject(id int, userID int) (model.Project, error)
+	UpdateProject(p model.Project, userID int) (model.Project, error)
+	DeleteProject(id int) error
+	GetProjectAttachments(projectID int) ([]model.Attachment, error)
+	GetProjectMembers(id int) ([]model.ProjectMemberDetails, error)
+	UpdateProjectMember(m model.ProjectMember) error
+	DeleteProjectMember(id int, userID int) error
+	CreateProjectInvitation(inv model.ProjectInvitation) (model.ProjectInvitation, error)
+	GetProjectInvitations(id int) ([]model.ProjectInvitation, error)
+	DeleteProjectInvitation(id int, invitationID int) error
+	GetProjectInvitation(tokenHash string) (model.ProjectInvitation, error)
+	AcceptProjectInvitation(tokenHash string, user model.User) (model.ProjectMember, error)
+}
+
+// ProjectResource holds a projectStore interface, used to communicate with the database, the mailer.Mailer used
+// to deliver project invitations and the blob.BlobStore that keeps the content of the task attachments
+type ProjectResource struct {
+	Store  projectStore
+	Mailer mailer.Mailer
+	Blobs  blob.BlobStore
+}
+
+// NewProjectResource initializes the ProjectResource with an existing ProjectStore, mailer.Mailer and blob.BlobStore
+func NewProjectResource(store projectStore, mail mailer.Mailer, blobs blob.BlobStore) *ProjectResource {
+	return &ProjectResource{
+		Store:  store,
+		Mailer: mail,
+		Blobs:  blobs,
+	}
+}
+
+// MountProjectRoutesTo defines new routes regarding Projects, their members and invitations on an existing
+// gin.RouterGroup or gin.Engine
+func (pr *ProjectResource) MountProjectRoutesTo(r gin.IRouter) {
+	var (
+		idParam           = middleware.Param{Key: "id", ExampleValue: -1}
+		userIdParam       = middleware.Param{Key: "user_id", ExampleValue: -1}
+		invitationIdParam = middleware.Param{Key: "invitation_id", ExampleValue: -1}
+		canRead           = middleware.Authorize(auth.PermissionReadTasks)
+		canWrite          = middleware.Authorize(auth.PermissionWriteTasks)
+		isViewer          = requireProjectRole(pr.Store, model.ProjectRoleViewer)
+		isOwner           = requireProjectRole(pr.Store, model.ProjectRoleOwner)
+	)
+
+	rg := r.Group("/projects"); {
+		rg.GET("", canRead, pr.handleGetProjects)
+		rg.POST("", canWrite, pr.handleCreateProject)
+		rg.POST("/invitations/accept", canWrite, pr.handleAcceptInvitation)
+		withId := rg.Group("", middleware.ExtractParam(idParam)); {
+			withId.GET("/:id", canRead, isViewer, pr.handleGetProject)
+			withId.PUT("/:id", canWrite, isOwner, pr.handleUpdateProject)
+			withId.DELETE("/:id", canWrite, isOwner, pr.handleDeleteProject)
+			withId.GET("/:id/members", canRead, isViewer, pr.handleGetMembers)
+			withId.PUT("/:id/members/:user_id", canWrite, isOwner, middleware.ExtractParam(userIdParam),
+				pr.handleUpdateMember)
+			withId.DELETE("/:id/members/:user_id", canWrite, isViewer, middleware.ExtractParam(userIdParam),
+				pr.handleDeleteMember)
+			withId.GET("/:id/invitations", canRead, isOwner, pr.handleGetInvitations)
+			withId.POST("/:id/invitations", canWrite, isOwner, pr.handleCreateInvitation)
+			withId.DELETE("/:id/invitations/:invitation_id", canWrite, isOwner,
+				middleware.ExtractParam(invitationIdParam), pr.handleDeleteInvitation)
+		}
+	}
+}
+
+// MountInvitationRoutesTo mounts the public routes of the project invitations, that don't require authentication, to
+// the specified router
+func (pr *ProjectResource) MountInvitationRoutesTo(r gin.IRouter) {
+	tokenParam := middleware.Param{Key: "token", ExampleValue: "", IsQuery: true}
+
+	r.GET("/projects/invitations/accept", middleware.ExtractParam(tokenParam), pr.handleCheckInvitation)
+}
+
+// requireProjectRole is a middleware for gin that only lets through the members of the project with the <id>
+// passed on the request url path that have at least the role <minimum>. Their role is stored on the gin.Context as
+// "project_role"
+func requireProjectRole(store projectRoleStore, minimum string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, ok := requireAccess(c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		role, ok := checkProjectRole(c, store, c.GetInt("id"), access.UserID, minimum)
+		if !ok {
+			c.Abort()
+			return
+		}
+		c.Set("project_role", role)
+		c.Next()
+	}
+}
+
+// checkProjectRole checks if the user with the id <userID> has at least the role <minimum> on the project with the
+// specified id, and returns its role. If it doesn't, it responds with the status code and body returned by
+// projectRoleError and returns false
+func checkProjectRole(c *gin.Context, store projectRoleStore, id int, userID int, minimum string) (string, bool) {
+	role, _ := store.GetProjectRole(id, userID)
+	if status, body := projectRoleError(id, role, minimum); status != 0 {
+		c.JSON(status, body)
+		return role, false
+	}
+	return role, true
+}
+
+// projectRoleError returns a http.StatusNotFound status code if a user isn't a member of the project with the
+// specified id (its <role> is empty), or a http.StatusForbidden status code if its role is lower than <minimum>,
+// along with the body to respond with. Returns 0 if it has at least that role
+func projectRoleError(id int, role string, minimum string) (int, gin.H) {
+	if len(role) == 0 {
+		return http.StatusNotFound, errProjectIdNotFound(id)
+	}
+	if projectRoleRanks[role] < projectRoleRanks[minimum] {
+		return http.StatusForbidden, errProjectForbidden
+	}
+	return 0, nil
+}
+
+// handleCreateProject validates the project sent on the request body and inserts it, if it's valid, on the database.
+// The current user becomes its owner
+func (pr *ProjectResource) handleCreateProject(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var p model.Project
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errProjectInvalidFields)
+		return
+	}
+	p.ID = 0
+
+	newProject, err := pr.Store.CreateProject(p, access.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errProjectCreate)
+		return
+	}
+	c.JSON(http.StatusCreated, newProject)
+}
+
+// handleGetProjects returns all the projects the current user is a member of, with its role on each
+func (pr *ProjectResource) handleGetProjects(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	projects, err := pr.Store.GetUserProjects(access.UserID)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get all projects", err)
+	}
+	c.JSON(http.StatusOK, projects)
+}
+
+// handleGetProject returns the project with the <id> passed on the request url path, with the role of the current
+// user on it
+func (pr *ProjectResource) handleGetProject(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	p, err := pr.Store.GetProject(id, access.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errProjectIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// handleUpdateProject validates the project passed on the request body, and updates it (if it's valid), using the
+// <id> passed on the request url path. Only owners can update a project
+func (pr *ProjectResource) handleUpdateProject(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	var p model.Project
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errProjectInvalidFields)
+		return
+	}
+
+	p.ID = id
+	updatedProject, err := pr.Store.UpdateProject(p, access.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errProjectIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, updatedProject)
+}
+
+// handleDeleteProject deletes the project with the <id> passed on the request url path, along with its tasks,
+// members and invitations. Only owners can delete a project
+func (pr *ProjectResource) handleDeleteProject(c *gin.Context) {
+	id := c.GetInt("id")
+	attachments, err := pr.Store.GetProjectAttachments(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errProjectIdNotFound(id))
+		return
+	}
+	if err := pr.Store.DeleteProject(id); err != nil {
+		c.JSON(http.StatusNotFound, errProjectIdNotFound(id))
+		return
+	}
+	deleteAttachmentBlobs(pr.Blobs, attachments...)
+	c.JSON(http.StatusNoContent, "")
+}
+
+// handleGetMembers returns the members of the project with the <id> passed on the request url path
+func (pr *ProjectResource) handleGetMembers(c *gin.Context) {
+	members, err := pr.Store.GetProjectMembers(c.GetInt("id"))
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get project members", err)
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// handleUpdateMember changes the role of the member with the <user_id> passed on the request url path, on the
+// project with the <id> passed on it. Only owners can change roles, and the last owner can't be demoted
+func (pr *ProjectResource) handleUpdateMember(c *gin.Context) {
+	var body model.ProjectMemberUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errProjectInvalidFields)
+		return
+	}
+
+	member := model.ProjectMember{ProjectID: c.GetInt("id"), UserID: c.GetInt("user_id"), Role: body.Role}
+	if err := pr.Store.UpdateProjectMember(member); err != nil {
+		respondProjectMemberError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// handleDeleteMember removes the member with the <user_id> passed on the request url path from the project with the
+// <id> passed on it. Owners can remove any member, and other members can only leave the project themselves. The last
+// owner can't be removed
+func (pr *ProjectResource) handleDeleteMember(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	userID := c.GetInt("user_id")
+	if userID != access.UserID && c.GetString("project_role") != model.ProjectRoleOwner {
+		c.JSON(http.StatusForbidden, errProjectForbidden)
+		return
+	}
+	if err := pr.Store.DeleteProjectMember(c.GetInt("id"), userID); err != nil {
+		respondProjectMemberError(c, err)
+		return
+	}
+	c.JSON(http.StatusNoContent, "")
+}
+
+// respondProjectMemberError responds with a http.StatusConflict status code if the last owner of a project would be
+// removed or demoted, or with a http.StatusNotFound status code otherwise
+func respondProjectMemberError(c *gin.Context, err error) {
+	if errors.Is(err, model.ErrLastProjectOwner) {
+		c.JSON(http.StatusConflict, errProjectLastOwner)
+		return
+	}
+	c.JSON(http.StatusNotFound, errProjectMemberNotFound)
+}
+
+// handleGetInvitations returns the pending invitations to the project with the <id> passed on the request url path
+func (pr *ProjectResource) handleGetInvitations(c *gin.Context) {
+	invitations, err := pr.Store.GetProjectInvitations(c.GetInt("id"))
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get project invitations", err)
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// handleCreateInvitation invites the email passed on the request body to join the project with the <id> passed on
+// the request url path, with a role, by sending it a link with a single-use token that expires after
+// PROJECT_INVITATION_TTL. Only owners can invite
+func (pr *ProjectResource) handleCreateInvitation(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var inv model.ProjectInvitation
+	if err := c.ShouldBindJSON(&inv); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errProjectInvalidFields)
+		return
+	}
+	id := c.GetInt("id")
+	if user, err := pr.Store.GetUserBy("email", inv.Email); err == nil {
+		if _, err = pr.Store.GetProjectRole(id, user.ID); err == nil {
+			c.JSON(http.StatusConflict, errProjectAlreadyMember)
+			return
+		}
+	}
+
+	project, err := pr.Store.GetProject(id, access.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errProjectIdNotFound(id))
+		return
+	}
+	token, hash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate project invitation token", err)
+		c.JSON(http.StatusBadRequest, errProjectInvitationFailed)
+		return
+	}
+	newInvitation, err := pr.Store.CreateProjectInvitation(model.ProjectInvitation{
+		ProjectID:   id,
+		Email:       strings.ToLower(inv.Email),
+		Role:        inv.Role,
+		InvitedByID: access.UserID,
+		TokenHash:   hash,
+		ExpiresAt:   time.Now().Add(viper.GetDuration("PROJECT_INVITATION_TTL")),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errProjectInvitationFailed)
+		return
+	}
+
+	if err = pr.sendInvitation(newInvitation, project, token); err != nil {
+		logging.Logger.Errorln("[API] Failed to send project invitation", err)
+	}
+	c.JSON(http.StatusCreated, newInvitation)
+}
+
+// handleDeleteInvitation deletes the pending invitation with the <invitation_id> passed on the request url path, of
+// the project with the <id> passed on it
+func (pr *ProjectResource) handleDeleteInvitation(c *gin.Context) {
+	if err := pr.Store.DeleteProjectInvitation(c.GetInt("id"), c.GetInt("invitation_id")); err != nil {
+		c.JSON(http.StatusNotFound, errProjectInvitationNotFound)
+		return
+	}
+	c.JSON(http.StatusNoContent, "")
+}
+
+// handleCheckInvitation is the entry point of the link on the invitation email. It checks if the invitation token
+// passed on the request url query is still valid, without accepting it, since only the invited user can accept it,
+// by sending it to handleAcceptInvitation while signed in
+func (pr *ProjectResource) handleCheckInvitation(c *gin.Context) {
+	if _, err := pr.Store.GetProjectInvitation(auth.HashOpaqueToken(c.GetString("token"))); err != nil {
+		c.JSON(http.StatusNotFound, errProjectInvalidInvitation)
+		return
+	}
+	c.JSON(http.StatusOK, errProjectValidInvitation)
+}
+
+// handleAcceptInvitation validates the invitation token passed on the request body and adds the current user to the
+// invitation project, if the invitation was sent to its email. Users that are already members keep their role
+func (pr *ProjectResource) handleAcceptInvitation(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var body model.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errProjectInvalidFields)
+		return
+	}
+	user, err := pr.Store.GetUserBy("id", access.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errAuthUnauthorizedUser)
+		return
+	}
+
+	member, err := pr.Store.AcceptProjectInvitation(auth.HashOpaqueToken(body.Token), user)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errProjectInvalidInvitation)
+		return
+	}
+	c.JSON(http.StatusOK, member)
+}
+
+// sendInvitation sends an email with the invitation token to the invited email
+func (pr *ProjectResource) sendInvitation(inv model.ProjectInvitation, project model.Project, token string) error {
+	link := fmt.Sprintf("%v/projects/invitations/accept?token=%v", viper.GetString("PUBLIC_URL"),
+		url.QueryEscape(token))
+	return pr.Mailer.Send(mailer.Message{
+		To:      inv.Email,
+		Subject: "You were invited to join a project",
+		Body: fmt.Sprintf("Hi,\r\n\r\nYou were invited to join the project %v as %v. "+
+			"To accept the invitation, sign in with this email address and send the following token to "+
+			"POST /projects/invitations/accept:\r\n%v\r\n\r\n"+
+			"To check if the invitation is still valid, open the following link:\r\n%v\r\n\r\n"+
+			"The invitation expires on %v.\r\n", project.Name, inv.Role, token, link,
+			inv.ExpiresAt.Format(time.RFC1123)),
+	})
+}
@@ -0,0 +1,157 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/mailer"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/url"
+)
+
+var (
+	errRegistrationInvalidFields = gin.H{"message": "The specified registration has invalid fields"}
+	errRegistrationEmailTaken    = gin.H{"message": "The specified email is already registered"}
+	errRegistrationGeneric       = gin.H{"message": "Couldn't register user"}
+	errRegistrationSuccess       = gin.H{"message": "Successfully registered, please check your email to verify your account"}
+	errRegistrationResent        = gin.H{"message": "If the email belongs to a pending account, a new verification email was sent"}
+	errRegistrationInvalidToken  = gin.H{"message": "Invalid or expired verification token"}
+	errRegistrationVerified      = gin.H{"message": "Successfully verified email, you can now sign in"}
+)
+
+// registrationStore is used to define the database calls used by the route group define in this file
+type registrationStore interface {
+	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
+	GetUserByEmailIncludingTrash(email string) (model.User, error)
+	CreateUser(u model.User) (model.User, error)
+	MarkUserVerified(id int) error
+}
+
+// RegistrationResource holds a registrationStore interface, used to communicate with the database, and the
+// mailer.Mailer used to deliver verification emails
+type RegistrationResource struct {
+	Store  registrationStore
+	Mailer mailer.Mailer
+}
+
+// NewRegistrationResource initializes the RegistrationResource with an existing UserStore and mailer.Mailer
+func NewRegistrationResource(store registrationStore, mail mailer.Mailer) *RegistrationResource {
+	return &RegistrationResource{
+		Store:  store,
+		Mailer: mail,
+	}
+}
+
+// MountRegistrationRoutesTo defines new routes regarding user sign up on an existing gin.RouterGroup or gin.Engine.
+// These routes are public, so they shouldn't be mounted behind the auth middleware
+func (rr *RegistrationResource) MountRegistrationRoutesTo(r gin.IRouter) {
+	tokenParam := middleware.Param{Key: "token", ExampleValue: "", IsQuery: true}
+
+	r.POST("/register", rr.handleRegister)
+	r.POST("/register/resend", rr.handleResendVerification)
+	r.GET("/verify-email", middleware.ExtractParam(tokenParam), rr.handleVerifyEmail)
+}
+
+// handleRegister validates the registration sent on the request body, creates a new pending user with the member
+// role and sends it an email with a link to verify its email address
+func (rr *RegistrationResource) handleRegister(c *gin.Context) {
+	var reg model.Registration
+	if err := c.ShouldBindJSON(&reg); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errRegistrationInvalidFields)
+		return
+	}
+
+	if _, err := rr.Store.GetUserByEmailIncludingTrash(reg.Email); err == nil {
+		c.JSON(http.StatusConflict, errRegistrationEmailTaken)
+		return
+	}
+
+	hash, err := auth.GeneratePassword(reg.Password)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate hash from password", err)
+		c.JSON(http.StatusBadRequest, errRegistrationGeneric)
+		return
+	}
+
+	newUser, err := rr.Store.CreateUser(model.User{
+		FirstName: reg.FirstName,
+		LastName:  reg.LastName,
+		Email:     reg.Email,
+		Password:  hash,
+		Active:    true,
+		Role:      model.RoleMember,
+		Pending:   true,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errRegistrationGeneric)
+		return
+	}
+
+	if err = rr.sendVerificationEmail(newUser); err != nil {
+		logging.Logger.Errorln("[API] Failed to send verification email", err)
+	}
+	c.JSON(http.StatusCreated, errRegistrationSuccess)
+}
+
+// handleResendVerification sends a new verification email to the pending user with the email passed on the request
+// body. The response is the same whether the user exists or not, to avoid disclosing registered emails
+func (rr *RegistrationResource) handleResendVerification(c *gin.Context) {
+	var body model.VerificationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errRegistrationInvalidFields)
+		return
+	}
+
+	if user, err := rr.Store.GetUserBy("email", body.Email); err == nil && user.Pending {
+		if err = rr.sendVerificationEmail(user); err != nil {
+			logging.Logger.Errorln("[API] Failed to send verification email", err)
+		}
+	}
+	c.JSON(http.StatusAccepted, errRegistrationResent)
+}
+
+// handleVerifyEmail validates the verification token passed on the request url query and clears the pending state
+// of the user it was sent to, allowing it to sign in
+func (rr *RegistrationResource) handleVerifyEmail(c *gin.Context) {
+	id, email, err := auth.VerifyActionToken(c.GetString("token"), auth.PurposeEmailVerification)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errRegistrationInvalidToken)
+		return
+	}
+
+	user, err := rr.Store.GetUserBy("id", id)
+	if err != nil || user.Email != email {
+		c.JSON(http.StatusUnauthorized, errRegistrationInvalidToken)
+		return
+	}
+
+	if user.Pending {
+		if err = rr.Store.MarkUserVerified(user.ID); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, errRegistrationGeneric)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, errRegistrationVerified)
+}
+
+// sendVerificationEmail sends the user an email with a link to verify its email address, that expires after
+// EMAIL_VERIFICATION_TTL
+func (rr *RegistrationResource) sendVerificationEmail(user model.User) error {
+	token, err := auth.GenerateActionToken(auth.PurposeEmailVerification, user.ID, user.Email,
+		viper.GetDuration("EMAIL_VERIFICATION_TTL"))
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%v/verify-email?token=%v", viper.GetString("PUBLIC_URL"), url.QueryEscape(token))
+	return rr.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %v,\r\n\r\nPlease verify your email address by opening the following link:\r\n%v\r\n",
+			user.FirstName, link),
+	})
+}
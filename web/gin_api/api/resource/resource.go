@@ -0,0 +1,52 @@
+package resource
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errPreconditionFailed = gin.H{"message": "The resource was changed since it was read, get it again and retry"}
+)
+
+// requireAccess returns the access details of the current request, resolved by the auth middleware. If there are
+// none, it responds with a http.StatusUnauthorized status code and returns false
+func requireAccess(c *gin.Context) (auth.AccessDetails, bool) {
+	access, ok := middleware.GetAccessDetails(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, errAuthUnauthorizedUser)
+	}
+	return access, ok
+}
+
+// setETag sets the ETag header of a response with a resource, from its <version>
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", versionETag(version))
+}
+
+// versionETag returns the (strong) entity tag of a resource with the specified version
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// checkIfMatch checks if the If-Match header of the request, if any, matches the current <version> of the resource
+// being written, returning the version that must still be current when writing it, or 0 if the header wasn't
+// specified. If it doesn't match, it responds with a http.StatusPreconditionFailed status code and returns false
+func checkIfMatch(c *gin.Context, version int) (int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if len(header) == 0 {
+		return 0, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == versionETag(version) {
+			return version, true
+		}
+	}
+	setETag(c, version)
+	c.JSON(http.StatusPreconditionFailed, errPreconditionFailed)
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"net/http"
+)
+
+var (
+	errTagCreate        = gin.H{"message": "Couldn't create new tag"}
+	errTagInvalidFields = gin.H{"message": "The specified tag has invalid fields"}
+	errTagNameTaken     = gin.H{"message": "You already have a tag with the specified name"}
+	errTagIdNotFound    = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No tag with the id %v was found", param...)}
+	}
+)
+
+// tagStore is used to define the database calls used by the route group define in this file
+type tagStore interface {
+	CreateTag(tag model.Tag) (model.Tag, error)
+	GetTags(userID int) ([]model.Tag, error)
+	GetTag(id int, userID int) (model.Tag, error)
+	GetTagByName(userID int, name string) (model.Tag, error)
+	UpdateTag(tag model.Tag) (model.Tag, error)
+	DeleteTag(id int, userID int) error
+}
+
+// TagResource holds a tagStore interface, used to communicate with the database
+type TagResource struct {
+	Store tagStore
+}
+
+// NewTagResource initializes the TagResource with an existing TaskStore
+func NewTagResource(store tagStore) *TagResource {
+	return &TagResource{
+		Store: store,
+	}
+}
+
+// MountTagRoutesTo defines new routes regarding task Tags on an existing gin.RouterGroup or gin.Engine
+func (tr *TagResource) MountTagRoutesTo(r gin.IRouter) {
+	var (
+		idParam  = middleware.Param{Key: "id", ExampleValue: -1}
+		canRead  = middleware.Authorize(auth.PermissionReadTasks)
+		canWrite = middleware.Authorize(auth.PermissionWriteTasks)
+	)
+
+	rg := r.Group("/tags"); {
+		rg.GET("", canRead, tr.handleGetTags)
+		rg.POST("", canWrite, tr.handleCreateTag)
+		withId := rg.Group("", middleware.ExtractParam(idParam)); {
+			withId.GET("/:id", canRead, tr.handleGetTag)
+			withId.PUT("/:id", canWrite, tr.handleUpdateTag)
+			withId.DELETE("/:id", canWrite, tr.handleDeleteTag)
+		}
+	}
+}
+
+// handleCreateTag validates the tag sent on the request body and inserts it, if it's valid, on the database.
+// The tag belongs to the current user
+func (tr *TagResource) handleCreateTag(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	var tag model.Tag
+	if err := c.ShouldBindJSON(&tag); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTagInvalidFields)
+		return
+	}
+	tag.ID = 0
+	tag.UserID = access.UserID
+	if _, err := tr.Store.GetTagByName(access.UserID, tag.Name); err == nil {
+		c.JSON(http.StatusConflict, errTagNameTaken)
+		return
+	}
+
+	newTag, err := tr.Store.CreateTag(tag)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errTagCreate)
+		return
+	}
+	c.JSON(http.StatusCreated, newTag)
+}
+
+// handleGetTags returns all the tags of the current user
+func (tr *TagResource) handleGetTags(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	tags, err := tr.Store.GetTags(access.UserID)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get all tags", err)
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// handleGetTag returns the tag of the current user with the <id> passed on the request url path
+func (tr *TagResource) handleGetTag(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	tag, err := tr.Store.GetTag(id, access.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errTagIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, tag)
+}
+
+// handleUpdateTag validates the tag passed on the request body, and updates it (if it's valid),
+// using the <id> passed on the request url path. Only the tags of the current user can be updated
+func (tr *TagResource) handleUpdateTag(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	var tag model.Tag
+	if err := c.ShouldBindJSON(&tag); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTagInvalidFields)
+		return
+	}
+	if existing, err := tr.Store.GetTagByName(access.UserID, tag.Name); err == nil && existing.ID != id {
+		c.JSON(http.StatusConflict, errTagNameTaken)
+		return
+	}
+
+	tag.ID = id
+	tag.UserID = access.UserID
+	updatedTag, err := tr.Store.UpdateTag(tag)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errTagIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusOK, updatedTag)
+}
+
+// handleDeleteTag deletes a tag of the current user from the database, removing it from every task, using the <id>
+// passed on the request url path
+func (tr *TagResource) handleDeleteTag(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	if err := tr.Store.DeleteTag(id, access.UserID); err != nil {
+		c.JSON(http.StatusNotFound, errTagIdNotFound(id))
+		return
+	}
+	c.JSON(http.StatusNoContent, "")
+}
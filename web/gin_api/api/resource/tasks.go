@@ -1,35 +1,69 @@
 package resource
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jomifepe/gin_api/api/auth"
 	"github.com/jomifepe/gin_api/api/middleware"
 	"github.com/jomifepe/gin_api/logging"
 	"github.com/jomifepe/gin_api/model"
+	"github.com/jomifepe/gin_api/scheduler"
 	"net/http"
+	"time"
 )
 
 var (
 	errTaskCreate        = gin.H{"message": "Couldn't create new task"}
 	errTaskInvalidFields = gin.H{"message": "The specified task has invalid fields"}
 	errTaskToggle        = gin.H{"message": "Couldn't toggle task completed"}
+	errTaskSearch        = gin.H{"message": "Couldn't search tasks"}
+	errTaskInvalidTags   = gin.H{"message": "Some of the specified tags don't exist"}
+	errTaskRecurrence    = gin.H{"message": "The recurrence must be daily, weekly, monthly or a cron expression"}
+	errTaskInvalidLinks  = gin.H{"message": "The specified parent or blocking tasks don't exist"}
+	errTaskCycle         = gin.H{"message": "A task can't be its own ancestor or blocker"}
+	errTaskBlocked       = gin.H{"message": "This task can't be completed while the tasks blocking it are open"}
 	errTaskDelete        = func(param ...interface{}) map[string]interface{} {
 		return gin.H{"message": fmt.Sprintf("Couldn't delete task with id %v", param...)}
 	}
 	errTaskIdNotFound = func(param ...interface{}) map[string]interface{} {
 		return gin.H{"message": fmt.Sprintf("No task with the id %v was found", param...)}
 	}
+	errTaskNotInTrash = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No task with the id %v was found on the trash", param...)}
+	}
 )
 
+// taskPatchFields are the fields of a task that can be changed with a patch (see handlePatchTask), named after their
+// columns, apart from tag_ids and blocked_by
+var taskPatchFields = []string{
+	"description", "completed", "due_at", "priority", "notes", "parent_id", "recurrence", "tag_ids", "blocked_by",
+}
+
+// taskAccessStore is used to define the database calls used to check if a user can read or write a task
+type taskAccessStore interface {
+	projectRoleStore
+	GetTask(id int, userID int) (model.Task, error)
+}
+
 // taskStore is used to define the database calls used by the route group define in this file
 type taskStore interface {
+	taskAccessStore
 	CreateTask(task model.Task) (model.Task, error)
 	DeleteAccess(accessDetails auth.AccessDetails) error
-	UpdateTask(task model.Task) (model.Task, error)
-	GetTask(id int) (model.Task, error)
-	GetAllTasks() ([]model.Task, error)
-	DeleteTask(id int) error
+	UpdateTask(task model.Task, columns ...string) (model.Task, error)
+	ToggleTask(id int, userID int, version int) (model.Task, error)
+	GetTaskTree(id int, userID int) (model.TaskTree, error)
+	GetAllTasks(userID int, query model.TaskQuery) ([]model.Task, int64, error)
+	SearchTasks(userID int, search model.TaskSearch) ([]model.TaskSearchResult, int64, error)
+	GetTagsByID(userID int, ids []int) ([]model.Tag, error)
+	DeleteTask(id int, userID int, version int) error
+	GetTasksByID(ids []int, userID int) ([]model.Task, error)
+	ApplyTaskOperations(userID int, ops []model.TaskOperation, atomic bool) []error
+	GetTaskHistory(taskID int, page model.Page) ([]model.TaskActivity, int64, error)
+	GetDeletedTasks(userID int, page model.Page) ([]model.Task, int64, error)
+	RestoreTask(id int, userID int) (model.Task, error)
+	GetTaskIncludingTrash(id int, userID int) (model.Task, error)
 }
 
 // TaskResource holds a TaskStore interface, used to communicate with the database
@@ -46,59 +80,223 @@ func NewTaskResource(store taskStore) *TaskResource {
 
 // MountTaskRoutesTo defines new routes regarding Tasks on an existing gin.RouterGroup or gin.Engine
 func (tr *TaskResource) MountTaskRoutesTo(r gin.IRouter) {
-	idParam := middleware.Param{Key: "id", ExampleValue: -1}
+	var (
+		idParam      = middleware.Param{Key: "id", ExampleValue: -1}
+		projectParam = middleware.Param{Key: "project_id", ExampleValue: -1, IsQuery: true, Optional: true}
+		queryParams  = listParams(
+			projectParam,
+			middleware.Param{Key: "completed", ExampleValue: false, IsQuery: true, Optional: true},
+			middleware.Param{Key: "created_after", ExampleValue: time.Time{}, IsQuery: true, Optional: true},
+			middleware.Param{Key: "created_before", ExampleValue: time.Time{}, IsQuery: true, Optional: true},
+			middleware.Param{Key: "description", ExampleValue: "", IsQuery: true, Optional: true},
+			middleware.Param{Key: "tag", ExampleValue: "", IsQuery: true, Optional: true},
+			middleware.Param{Key: "priority", ExampleValue: -1, IsQuery: true, Optional: true},
+			middleware.Param{Key: "overdue", ExampleValue: false, IsQuery: true, Optional: true},
+		)
+		searchParams = listParams(middleware.Param{Key: "q", ExampleValue: "", IsQuery: true}, projectParam)
+		canRead      = middleware.Authorize(auth.PermissionReadTasks)
+		canWrite     = middleware.Authorize(auth.PermissionWriteTasks)
+	)
 
+	r.GET("/trash", canRead, middleware.ExtractParam(listParams()...), tr.handleGetDeletedTasks)
 	rg := r.Group("/tasks"); {
-		rg.GET("", tr.handleGetTasks)
-		rg.POST("", tr.handleCreateTask)
+		rg.GET("/search", canRead, middleware.ExtractParam(searchParams...), tr.handleSearchTasks)
+		rg.GET("", canRead, middleware.ExtractParam(queryParams...), tr.handleGetTasks)
+		rg.POST("", canWrite, tr.handleCreateTask)
+		rg.POST("/bulk", canWrite, tr.handleBulkTasks)
 		withId := rg.Group("", middleware.ExtractParam(idParam)); {
-			withId.GET("/:id", tr.handleGetTask)
-			withId.PUT("/:id", tr.handleUpdateTask)
-			withId.DELETE("/:id", tr.handleDeleteTask)
-			withId.PUT("/:id/toggle", tr.handleTaskToggle)
+			withId.GET("/:id", canRead, tr.handleGetTask)
+			withId.GET("/:id/tree", canRead, tr.handleGetTaskTree)
+			withId.GET("/:id/history", canRead, middleware.ExtractParam(listParams()...), tr.handleGetTaskHistory)
+			withId.PUT("/:id", canWrite, tr.handleUpdateTask)
+			withId.PATCH("/:id", canWrite, tr.handlePatchTask)
+			withId.DELETE("/:id", canWrite, tr.handleDeleteTask)
+			withId.POST("/:id/restore", canWrite, tr.handleRestoreTask)
+			withId.PUT("/:id/toggle", canWrite, tr.handleTaskToggle)
 		}
 	}
 }
 
-// handleCreateTask validates the task sent on the request body and inserts it, if it's valid, on the database
+// handleCreateTask validates the task sent on the request body and inserts it, if it's valid, on the database.
+// The task belongs to the current user, or to its project (<project_id>) if the user is an owner or editor of it
 func (tr *TaskResource) handleCreateTask(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
 	var t model.Task
 	if err := c.ShouldBindJSON(&t); err != nil {
 		c.JSON(http.StatusUnprocessableEntity, errTaskInvalidFields)
 		return
 	}
+	t.ID = 0
+	t.UserID = access.UserID
+	t.RecurredFromID = nil
+	if t.ProjectID != nil {
+		if _, ok := checkProjectRole(c, tr.Store, *t.ProjectID, access.UserID, model.ProjectRoleEditor); !ok {
+			return
+		}
+	}
+	if !tr.checkTaskTags(c, t) || !checkTaskRecurrence(c, t) {
+		return
+	}
 
 	newTask, err := tr.Store.CreateTask(t)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errTaskCreate)
+		if !respondTaskRuleError(c, err) {
+			c.JSON(http.StatusBadRequest, errTaskCreate)
+		}
 		return
 	}
+	setETag(c, newTask.Version)
 	c.JSON(http.StatusCreated, newTask)
 }
 
+// handleGetTask returns the task the current user can read with the <id> passed on the request url path
 func (tr *TaskResource) handleGetTask(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
 	id := c.GetInt("id")
-	t, err := tr.Store.GetTask(id)
+	t, err := tr.Store.GetTask(id, access.UserID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
 		return
 	}
 
+	setETag(c, t.Version)
 	c.JSON(http.StatusOK, t)
 }
 
-// handleGetTasks returns all the existing tasks
+// handleGetTaskTree returns the task the current user can read with the <id> passed on the request url path, along with
+// all its subtasks, recursively
+func (tr *TaskResource) handleGetTaskTree(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	tree, err := tr.Store.GetTaskTree(id, access.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+// handleGetTaskHistory returns a page of the activity log of the task the current user can read with the <id> passed
+// on the request url path, even if it's on the trash, oldest first unless another sort is specified (see extractPage
+// for pagination and sorting)
+func (tr *TaskResource) handleGetTaskHistory(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	page, ok := extractPage(c, "id", "created_at")
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	if _, err := tr.Store.GetTaskIncludingTrash(id, access.UserID); err != nil {
+		c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
+		return
+	}
+
+	history, total, err := tr.Store.GetTaskHistory(id, page)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get task history", err)
+	}
+	setPageHeaders(c, page, total)
+	c.JSON(http.StatusOK, history)
+}
+
+// handleGetTasks returns a page of the tasks the current user can read, filtered by the <project_id>, <completed>,
+// <created_after>, <created_before>, <description>, <tag>, <priority> and <overdue> query parameters, if specified
+// (see extractPage for pagination and sorting)
 func (tr *TaskResource) handleGetTasks(c *gin.Context) {
-	tc, err := tr.Store.GetAllTasks()
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	page, ok := extractPage(c, "id", "description", "completed", "due_at", "priority", "created_at", "updated_at")
+	if !ok {
+		return
+	}
+
+	projectID := optionalInt(c, "project_id")
+	if !tr.checkProjectFilter(c, projectID, access.UserID) {
+		return
+	}
+
+	tc, total, err := tr.Store.GetAllTasks(access.UserID, model.TaskQuery{
+		Page:          page,
+		ProjectID:     projectID,
+		Completed:     optionalBool(c, "completed"),
+		CreatedAfter:  optionalTime(c, "created_after"),
+		CreatedBefore: optionalTime(c, "created_before"),
+		Description:   c.GetString("description"),
+		Tag:           c.GetString("tag"),
+		Priority:      optionalInt(c, "priority"),
+		Overdue:       optionalBool(c, "overdue"),
+	})
 	if err != nil {
 		logging.Logger.Errorln("[API] Failed to get all tasks", err)
 	}
+	setPageHeaders(c, page, total)
 	c.JSON(http.StatusOK, tc)
 }
 
+// handleSearchTasks returns a page of the tasks the current user can read whose description matches the text of the
+// <q> query parameter, filtered by <project_id> if specified, sorted by relevance unless another sort is specified
+// (see extractPage for pagination and sorting)
+func (tr *TaskResource) handleSearchTasks(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	page, ok := extractPage(c, "rank", "id", "description", "completed", "due_at", "priority", "created_at",
+		"updated_at")
+	if !ok {
+		return
+	}
+	if len(c.GetString("sort")) == 0 {
+		page.Sort, page.Desc = "rank", true
+	}
+
+	projectID := optionalInt(c, "project_id")
+	if !tr.checkProjectFilter(c, projectID, access.UserID) {
+		return
+	}
+
+	results, total, err := tr.Store.SearchTasks(access.UserID, model.TaskSearch{
+		Page:      page,
+		Query:     c.GetString("q"),
+		ProjectID: projectID,
+	})
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to search tasks", err)
+		c.JSON(http.StatusUnprocessableEntity, errTaskSearch)
+		return
+	}
+	setPageHeaders(c, page, total)
+	c.JSON(http.StatusOK, results)
+}
+
 // handleUpdateTask validates the task passed on the request body, and updates it (if it's valid),
-// using the <id> passed on the request url path
+// using the <id> passed on the request url path, if it matches the If-Match header (see checkIfMatch). Only the
+// personal tasks of the current user and the tasks of the projects it's an owner or editor of can be updated
 func (tr *TaskResource) handleUpdateTask(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
 	id := c.GetInt("id")
 	var t model.Task
 	if err := c.ShouldBindJSON(&t); err != nil {
@@ -107,40 +305,301 @@ func (tr *TaskResource) handleUpdateTask(c *gin.Context) {
 	}
 
 	t.ID = id
+	t.UserID = access.UserID
+	current, ok := checkTaskWritable(c, tr.Store, id, access.UserID)
+	if !ok {
+		return
+	}
+	if t.Version, ok = checkIfMatch(c, current.Version); !ok {
+		return
+	}
+	if !tr.checkTaskTags(c, t) || !checkTaskRecurrence(c, t) {
+		return
+	}
 	updatedTask, err := tr.Store.UpdateTask(t)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, errTaskDelete(id))
+		if !respondTaskRuleError(c, err) {
+			c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
+		}
 		return
 	}
 
+	setETag(c, updatedTask.Version)
 	c.JSON(http.StatusOK, updatedTask)
 }
 
-// handleDeleteTask deletes a task from the database using the <id> passed on the request url path
+// handlePatchTask applies the patch on the request body (see applyPatch) to the task with the <id> passed on the
+// request url path, if it matches the If-Match header (see checkIfMatch), and updates only the fields that changed,
+// if the resulting task is valid. Its tags and blockers are replaced if tag_ids or blocked_by are changed
+func (tr *TaskResource) handlePatchTask(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	current, ok := checkTaskWritable(c, tr.Store, id, access.UserID)
+	if !ok {
+		return
+	}
+	version, ok := checkIfMatch(c, current.Version)
+	if !ok {
+		return
+	}
+	var t model.Task
+	changed, ok := applyPatch(c, current, &t, errTaskInvalidFields, taskPatchFields...)
+	if !ok {
+		return
+	}
+	if len(changed) == 0 {
+		setETag(c, current.Version)
+		c.JSON(http.StatusOK, current)
+		return
+	}
+	if err := validateFields(t); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTaskInvalidFields)
+		return
+	}
+
+	// tags and blockers aren't columns, so only the update date is written when they're the only change
+	tagIDs, blockedBy, columns := t.TagIDs, t.BlockedBy, []string{"updated_at"}
+	t.TagIDs, t.BlockedBy = nil, nil
+	for _, field := range changed {
+		switch field {
+		case "tag_ids":
+			t.TagIDs = append([]int{}, tagIDs...)
+		case "blocked_by":
+			t.BlockedBy = append([]int{}, blockedBy...)
+		default:
+			columns = append(columns, field)
+		}
+	}
+	t.ID, t.UserID, t.Version = id, access.UserID, version
+	if !tr.checkTaskTags(c, t) || !checkTaskRecurrence(c, t) {
+		return
+	}
+	updatedTask, err := tr.Store.UpdateTask(t, columns...)
+	if err != nil {
+		if !respondTaskRuleError(c, err) {
+			c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
+		}
+		return
+	}
+
+	setETag(c, updatedTask.Version)
+	c.JSON(http.StatusOK, updatedTask)
+}
+
+// checkTaskTags checks if the tags to set on a task (<t.TagIDs>) exist and belong to the task user. If they don't, it
+// responds with the status code and body returned by taskTagsError and returns false
+func (tr *TaskResource) checkTaskTags(c *gin.Context, t model.Task) bool {
+	if len(t.TagIDs) == 0 {
+		return true
+	}
+	tags, _ := tr.Store.GetTagsByID(t.UserID, t.TagIDs)
+	if status, body := taskTagsError(tags, t.TagIDs); status != 0 {
+		c.JSON(status, body)
+		return false
+	}
+	return true
+}
+
+// taskTagsError returns a http.StatusUnprocessableEntity status code if any of the tags with the ids <ids> isn't on
+// <tags>, along with the body to respond with. Returns 0 if all of them are
+func taskTagsError(tags []model.Tag, ids []int) (int, gin.H) {
+	found := make(map[int]bool, len(tags))
+	for _, tag := range tags {
+		found[tag.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return http.StatusUnprocessableEntity, errTaskInvalidTags
+		}
+	}
+	return 0, nil
+}
+
+// checkTaskReadable checks if the user with the id <userID> can read the task with the specified id, and returns it.
+// If it can't, it responds with a http.StatusNotFound status code and returns false
+func checkTaskReadable(c *gin.Context, store taskAccessStore, id int, userID int) (model.Task, bool) {
+	t, err := store.GetTask(id, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
+		return model.Task{}, false
+	}
+	return t, true
+}
+
+// checkTaskWritable checks if the user with the id <userID> can write the task with the specified id, and returns
+// it. If it can't read it, it responds with a http.StatusNotFound status code, or with a http.StatusForbidden status
+// code if it's only a viewer of the task project, and returns false
+func checkTaskWritable(c *gin.Context, store taskAccessStore, id int, userID int) (model.Task, bool) {
+	t, ok := checkTaskReadable(c, store, id, userID)
+	if !ok || t.ProjectID == nil {
+		return t, ok
+	}
+	if _, ok := checkProjectRole(c, store, *t.ProjectID, userID, model.ProjectRoleEditor); !ok {
+		return model.Task{}, false
+	}
+	return t, true
+}
+
+// checkProjectFilter checks if the user with the id <userID> is a member of the project tasks are being filtered by,
+// if any (0 filters the personal tasks). If it isn't, it responds with a http.StatusNotFound status code and returns
+// false
+func (tr *TaskResource) checkProjectFilter(c *gin.Context, projectID *int, userID int) bool {
+	if projectID == nil || *projectID == 0 {
+		return true
+	}
+	_, ok := checkProjectRole(c, tr.Store, *projectID, userID, model.ProjectRoleViewer)
+	return ok
+}
+
+// checkTaskRecurrence checks if the recurrence of a task, if any, is valid. If it isn't, it responds with the status
+// code and body returned by taskRecurrenceError and returns false
+func checkTaskRecurrence(c *gin.Context, t model.Task) bool {
+	if status, body := taskRecurrenceError(t); status != 0 {
+		c.JSON(status, body)
+		return false
+	}
+	return true
+}
+
+// taskRecurrenceError returns a http.StatusUnprocessableEntity status code if the recurrence of a task isn't valid,
+// along with the body to respond with. Returns 0 if it is, or if the task doesn't recur
+func taskRecurrenceError(t model.Task) (int, gin.H) {
+	if len(t.Recurrence) == 0 {
+		return 0, nil
+	}
+	if _, err := scheduler.ParseRecurrence(t.Recurrence); err != nil {
+		return http.StatusUnprocessableEntity, errTaskRecurrence
+	}
+	return 0, nil
+}
+
+// respondTaskRuleError responds with the status code and body returned by taskRuleError, if the error is one of those.
+// Returns false if it isn't
+func respondTaskRuleError(c *gin.Context, err error) bool {
+	status, body := taskRuleError(err)
+	if status == 0 {
+		return false
+	}
+	c.JSON(status, body)
+	return true
+}
+
+// taskRuleError returns a http.StatusUnprocessableEntity status code if the parent or blockers of a task don't exist,
+// a http.StatusConflict status code if they would create a cycle or the task is being completed while blocked, or a
+// http.StatusPreconditionFailed status code if the task was changed since it was read, along with the body to respond
+// with. Returns 0 if the error isn't any of these
+func taskRuleError(err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, model.ErrVersionConflict):
+		return http.StatusPreconditionFailed, errPreconditionFailed
+	case errors.Is(err, model.ErrTaskLinkNotFound):
+		return http.StatusUnprocessableEntity, errTaskInvalidLinks
+	case errors.Is(err, model.ErrTaskCycle):
+		return http.StatusConflict, errTaskCycle
+	case errors.Is(err, model.ErrTaskBlocked):
+		return http.StatusConflict, errTaskBlocked
+	default:
+		return 0, nil
+	}
+}
+
+// handleDeleteTask moves a task the current user can write to the trash, using the <id> passed on the request url
+// path, if it matches the If-Match header (see checkIfMatch). Its subtasks become top-level tasks, until it's restored
 func (tr *TaskResource) handleDeleteTask(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
 	id := c.GetInt("id")
-	if err := tr.Store.DeleteTask(id); err != nil {
+	current, ok := checkTaskWritable(c, tr.Store, id, access.UserID)
+	if !ok {
+		return
+	}
+	version, ok := checkIfMatch(c, current.Version)
+	if !ok {
+		return
+	}
+	if err := tr.Store.DeleteTask(id, access.UserID, version); err != nil {
+		if errors.Is(err, model.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, errPreconditionFailed)
+			return
+		}
 		c.JSON(http.StatusNotFound, errTaskDelete(id))
 		return
 	}
 	c.JSON(http.StatusNoContent, "")
 }
 
-// handleTaskToggle toggles a tasks completed field, using the <id> passed on the request url path
-func (tr *TaskResource) handleTaskToggle(c *gin.Context) {
+// handleGetDeletedTasks returns a page of the tasks on the trash that the current user can restore, until they're
+// purged (see extractPage for pagination and sorting)
+func (tr *TaskResource) handleGetDeletedTasks(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+	page, ok := extractPage(c, "id", "description", "created_at", "updated_at", "deleted_at")
+	if !ok {
+		return
+	}
+
+	tasks, total, err := tr.Store.GetDeletedTasks(access.UserID, page)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get deleted tasks", err)
+	}
+	setPageHeaders(c, page, total)
+	c.JSON(http.StatusOK, tasks)
+}
+
+// handleRestoreTask takes a task the current user can write out of the trash, using the <id> passed on the request
+// url path, along with its tags, dependencies, comments and attachments
+func (tr *TaskResource) handleRestoreTask(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
 	id := c.GetInt("id")
-	t, err := tr.Store.GetTask(id)
+	restoredTask, err := tr.Store.RestoreTask(id, access.UserID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, errTaskIdNotFound(id))
+		c.JSON(http.StatusNotFound, errTaskNotInTrash(id))
 		return
 	}
+	setETag(c, restoredTask.Version)
+	c.JSON(http.StatusOK, restoredTask)
+}
 
-	t.Completed = !t.Completed
-	updatedTask, err := tr.Store.UpdateTask(t)
+// handleTaskToggle toggles the completed field of a task the current user can write, using the <id> passed on the
+// request url path, if it matches the If-Match header (see checkIfMatch). A task can't be completed while any of its
+// blockers aren't
+func (tr *TaskResource) handleTaskToggle(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	current, ok := checkTaskWritable(c, tr.Store, id, access.UserID)
+	if !ok {
+		return
+	}
+	version, ok := checkIfMatch(c, current.Version)
+	if !ok {
+		return
+	}
+
+	updatedTask, err := tr.Store.ToggleTask(id, access.UserID, version)
 	if err != nil {
-		c.JSON(http.StatusNotFound, errTaskToggle)
+		if !respondTaskRuleError(c, err) {
+			c.JSON(http.StatusNotFound, errTaskToggle)
+		}
 		return
 	}
 
+	setETag(c, updatedTask.Version)
 	c.JSON(http.StatusOK, updatedTask)
 }
\ No newline at end of file
@@ -0,0 +1,216 @@
+package resource
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodesCount = 10
+)
+
+var (
+	errTwoFactorInvalidFields   = gin.H{"message": "The specified two-factor details have invalid fields"}
+	errTwoFactorAlreadyEnabled  = gin.H{"message": "Two-factor authentication is already enabled"}
+	errTwoFactorNotEnabled      = gin.H{"message": "Two-factor authentication is not enabled"}
+	errTwoFactorNotEnrolled     = gin.H{"message": "Please enroll in two-factor authentication first"}
+	errTwoFactorInvalidCode     = gin.H{"message": "Invalid two-factor authentication code"}
+	errTwoFactorInvalidPassword = gin.H{"message": "The current password is incorrect"}
+	errTwoFactorGeneric         = gin.H{"message": "Couldn't update two-factor authentication"}
+	errTwoFactorDisabled        = gin.H{"message": "Successfully disabled two-factor authentication"}
+)
+
+// secondFactorStore is used to define the database calls used to verify TOTP and recovery codes
+type secondFactorStore interface {
+	UseTOTPStep(userID int, step int64) (bool, error)
+	GetRecoveryCodes(userID int) ([]model.RecoveryCode, error)
+	UseRecoveryCode(id int) (bool, error)
+}
+
+// twoFactorStore is used to define the database calls used by the route group define in this file
+type twoFactorStore interface {
+	secondFactorStore
+	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
+	SetUserTOTPSecret(userID int, secret string) error
+	EnableUserTOTP(userID int, step int64, codeHashes []string) error
+	DisableUserTOTP(userID int) error
+}
+
+// TwoFactorResource holds a twoFactorStore interface, used to communicate with the database
+type TwoFactorResource struct {
+	Store twoFactorStore
+}
+
+// NewTwoFactorResource initializes the TwoFactorResource with an existing AuthStore
+func NewTwoFactorResource(store twoFactorStore) *TwoFactorResource {
+	return &TwoFactorResource{
+		Store: store,
+	}
+}
+
+// MountTwoFactorRoutesTo defines new routes regarding two-factor authentication on an existing gin.RouterGroup or
+// gin.Engine
+func (tfr *TwoFactorResource) MountTwoFactorRoutesTo(r gin.IRouter) {
+	rg := r.Group("/me/2fa", middleware.RequireSession()); {
+		rg.POST("/enroll", tfr.handleEnroll)
+		rg.POST("/confirm", tfr.handleConfirm)
+		rg.DELETE("", tfr.handleDisable)
+	}
+}
+
+// handleEnroll generates a new TOTP secret for the current user and returns it, along with its otpauth URI.
+// Two-factor authentication is only enabled once a code generated with the secret is confirmed
+func (tfr *TwoFactorResource) handleEnroll(c *gin.Context) {
+	user, ok := tfr.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, errTwoFactorAlreadyEnabled)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate TOTP secret", err)
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorGeneric)
+		return
+	}
+	if err = tfr.Store.SetUserTOTPSecret(user.ID, secret); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorGeneric)
+		return
+	}
+
+	c.JSON(http.StatusOK, model.TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, viper.GetString("TOTP_ISSUER"), user.Email),
+	})
+}
+
+// handleConfirm validates the code passed on the request body against the enrolled TOTP secret and, if it's valid,
+// enables two-factor authentication for the current user. Returns a new set of recovery codes, that are only
+// shown this time
+func (tfr *TwoFactorResource) handleConfirm(c *gin.Context) {
+	var body model.TOTPConfirmation
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorInvalidFields)
+		return
+	}
+
+	user, ok := tfr.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, errTwoFactorAlreadyEnabled)
+		return
+	}
+	if len(user.TOTPSecret) == 0 {
+		c.JSON(http.StatusConflict, errTwoFactorNotEnrolled)
+		return
+	}
+
+	step, valid := auth.ValidateTOTP(user.TOTPSecret, strings.TrimSpace(body.Code), time.Now())
+	if !valid {
+		c.JSON(http.StatusUnauthorized, errTwoFactorInvalidCode)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to generate recovery codes", err)
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorGeneric)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		if hashes[i], err = auth.GeneratePassword(auth.NormalizeRecoveryCode(code)); err != nil {
+			logging.Logger.Errorln("[API] Failed to generate hash from recovery code", err)
+			c.JSON(http.StatusUnprocessableEntity, errTwoFactorGeneric)
+			return
+		}
+	}
+	if err = tfr.Store.EnableUserTOTP(user.ID, step, hashes); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorGeneric)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// handleDisable disables two-factor authentication for the current user, after confirming its identity with the
+// password and a TOTP or recovery code passed on the request body
+func (tfr *TwoFactorResource) handleDisable(c *gin.Context) {
+	var body model.TOTPDisable
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorInvalidFields)
+		return
+	}
+
+	user, ok := tfr.currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusConflict, errTwoFactorNotEnabled)
+		return
+	}
+	if err := auth.ComparePasswords(body.Password, user.Password); err != nil {
+		c.JSON(http.StatusForbidden, errTwoFactorInvalidPassword)
+		return
+	}
+	if !verifySecondFactor(tfr.Store, user, body.Code) {
+		c.JSON(http.StatusForbidden, errTwoFactorInvalidCode)
+		return
+	}
+
+	if err := tfr.Store.DisableUserTOTP(user.ID); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errTwoFactorGeneric)
+		return
+	}
+	c.JSON(http.StatusOK, errTwoFactorDisabled)
+}
+
+// currentUser returns the current user, with all its fields. If it can't be found, it responds with a
+// http.StatusUnauthorized status code and returns false
+func (tfr *TwoFactorResource) currentUser(c *gin.Context) (model.User, bool) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return model.User{}, false
+	}
+	user, err := tfr.Store.GetUserBy("id", access.UserID, "")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, errAuthUnauthorizedUser)
+		return model.User{}, false
+	}
+	return user, true
+}
+
+// verifySecondFactor checks if <code> is either a valid TOTP code of the user, that wasn't used before, or one of its
+// unused recovery codes. The code is marked as used, so that it can't be replayed
+func verifySecondFactor(store secondFactorStore, user model.User, code string) bool {
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, strings.TrimSpace(code), time.Now()); ok {
+		used, err := store.UseTOTPStep(user.ID, step)
+		return err == nil && used
+	}
+
+	recoveryCodes, err := store.GetRecoveryCodes(user.ID)
+	if err != nil {
+		return false
+	}
+	normalized := auth.NormalizeRecoveryCode(code)
+	for _, rc := range recoveryCodes {
+		if auth.ComparePasswords(normalized, rc.CodeHash) == nil {
+			used, err := store.UseRecoveryCode(rc.ID)
+			return err == nil && used
+		}
+	}
+	return false
+}
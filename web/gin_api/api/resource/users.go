@@ -1,6 +1,7 @@
 package resource
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jomifepe/gin_api/api/auth"
@@ -13,15 +14,31 @@ import (
 var (
 	errUserCreateInvalidFields = gin.H{"message": "The specified user has invalid fields"}
 	errUserCreateGeneric       = gin.H{"message": "Couldn't create user"}
+	errUserUpdateGeneric       = gin.H{"message": "Couldn't update user"}
+	errUserEmailTaken          = gin.H{"message": "The specified email is already in use by another user"}
+	errUserSelfChange          = gin.H{"message": "You can't deactivate, demote or delete your own account"}
+	errUserIdNotFound          = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No user with the id %v was found", param...)}
+	}
+	errUserNotInTrash = func(param ...interface{}) map[string]interface{} {
+		return gin.H{"message": fmt.Sprintf("No user with the id %v was found on the trash", param...)}
+	}
 )
 
+// userPatchFields are the fields of a user that can be changed with a patch (see handlePatchUser)
+var userPatchFields = []string{"first_name", "last_name", "email", "active", "role"}
+
 // userStore is used to define the database calls used by the route group define in this file
 type userStore interface {
-	GetAllUsers(omitFields ...string) ([]model.User, error)
+	GetAllUsers(query model.UserQuery, omitFields ...string) ([]model.User, int64, error)
 	GetUserBy(paramName string, param interface{}, omitFields ...string) (model.User, error)
+	GetUserByEmailIncludingTrash(email string) (model.User, error)
 	CreateUser(u model.User) (model.User, error)
-	UpdateUser(u model.User) (model.User, error)
-	DeleteUser(id int) error
+	UpdateUser(u model.User, columns ...string) (model.User, error)
+	DeleteUser(id int, version int) error
+	DeleteUserAccesses(userID int, keepFamilyUUIDs ...string) error
+	GetDeletedUsers(page model.Page) ([]model.User, int64, error)
+	RestoreUser(id int) (model.User, error)
 }
 
 // UserResource holds a TaskStore interface, used to communicate with the database
@@ -38,31 +55,43 @@ func NewUserResource(store userStore) *UserResource {
 
 // MountUserRoutesTo defines new routes regarding Users on an existing gin.RouterGroup or gin.Engine
 func (ur *UserResource) MountUserRoutesTo(r gin.IRouter) {
-	idParam := middleware.Param{Key: "id", ExampleValue: -1}
+	var (
+		idParam     = middleware.Param{Key: "id", ExampleValue: -1}
+		queryParams = listParams(
+			middleware.Param{Key: "email", ExampleValue: "", IsQuery: true, Optional: true},
+			middleware.Param{Key: "name", ExampleValue: "", IsQuery: true, Optional: true},
+			middleware.Param{Key: "active", ExampleValue: false, IsQuery: true, Optional: true},
+		)
+		canRead  = middleware.Authorize(auth.PermissionReadUsers)
+		canWrite = middleware.Authorize(auth.PermissionWriteUsers)
+	)
 
 	r.GET("/me", ur.handleMe)
+	r.GET("/trash/users", canRead, middleware.ExtractParam(listParams()...), ur.handleGetDeletedUsers)
 	rg := r.Group("/users"); {
-		rg.GET("", ur.handleGetUsers)
-		rg.POST("", ur.handleCreateUser)
+		rg.GET("", canRead, middleware.ExtractParam(queryParams...), ur.handleGetUsers)
+		rg.POST("", canWrite, ur.handleCreateUser)
 		withId := rg.Group("", middleware.ExtractParam(idParam)); {
-			withId.GET("/:id", ur.handleGetUser)
-			withId.PUT("/:id", ur.handleUpdateUser)
+			withId.GET("/:id", canRead, ur.handleGetUser)
+			withId.PUT("/:id", canWrite, ur.handleUpdateUser)
+			withId.PATCH("/:id", canWrite, ur.handlePatchUser)
+			withId.DELETE("/:id", canWrite, ur.handleDeleteUser)
+			withId.POST("/:id/restore", canWrite, ur.handleRestoreUser)
+			withId.POST("/:id/activate", canWrite, ur.handleSetUserActive(true))
+			withId.POST("/:id/deactivate", canWrite, ur.handleSetUserActive(false))
 		}
 	}
 }
 
-// handleMe returns the current user information by extracting its id from the access token used on
+// handleMe returns the current user information by extracting its id from the access token (or API key) used on
 // the request and querying the database
 func (ur *UserResource) handleMe(c *gin.Context) {
-	metadata, err := auth.ExtractRequestTokenMetadata(c.Request)
-	if err != nil {
-		c.JSON(http.StatusUnprocessableEntity, gin.H{
-			"message": err,
-		})
+	access, ok := requireAccess(c)
+	if !ok {
 		return
 	}
 
-	user, err := ur.Store.GetUserBy("id", metadata.UserID, "password")
+	user, err := ur.Store.GetUserBy("id", access.UserID, "password")
 	if err != nil {
 		c.JSON(http.StatusUnprocessableEntity, gin.H{
 			"message": "Invalid token",
@@ -70,16 +99,29 @@ func (ur *UserResource) handleMe(c *gin.Context) {
 		return
 	}
 
+	setETag(c, user.Version)
 	c.JSON(http.StatusOK, user)
 }
 
-// handleGetUsers returns all the existing users
+// handleGetUsers returns a page of the existing users, filtered by the <email>, <name> and <active> query
+// parameters, if specified (see extractPage for pagination and sorting)
 func (ur *UserResource) handleGetUsers(c *gin.Context) {
-	users, err := ur.Store.GetAllUsers()
+	page, ok := extractPage(c, "id", "first_name", "last_name", "email", "active")
+	if !ok {
+		return
+	}
+
+	users, total, err := ur.Store.GetAllUsers(model.UserQuery{
+		Page:   page,
+		Email:  c.GetString("email"),
+		Name:   c.GetString("name"),
+		Active: optionalBool(c, "active"),
+	})
 	if err != nil {
 		logging.Logger.Errorln("[API] Failed to get all users", err)
 	}
 
+	setPageHeaders(c, page, total)
 	c.JSON(http.StatusOK, users)
 }
 
@@ -88,12 +130,11 @@ func (ur *UserResource) handleGetUser(c *gin.Context) {
 
 	u, err := ur.Store.GetUserBy("id", id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"message": fmt.Sprintf("No user with the id %v was found", id),
-		})
+		c.JSON(http.StatusNotFound, errUserIdNotFound(id))
 		return
 	}
 
+	setETag(c, u.Version)
 	c.JSON(http.StatusOK, u)
 }
 
@@ -122,11 +163,191 @@ func (ur *UserResource) handleCreateUser(c *gin.Context) {
 	}
 
 	newUser.Password = ""
+	setETag(c, newUser.Version)
 	c.JSON(http.StatusCreated, newUser)
 }
 
+// handleUpdateUser validates the fields specified on the request body and updates them on the user with the <id>
+// passed on the request url path, leaving the unspecified ones unchanged. Deactivating a user signs it out of
+// every session
 func (ur *UserResource) handleUpdateUser(c *gin.Context) {
-	c.JSON(http.StatusNotFound, gin.H{
-		"message": "TODO",
-	})
+	var uu model.UserUpdate
+	if err := c.ShouldBindJSON(&uu); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errUserCreateInvalidFields)
+		return
+	}
+	ur.updateUser(c, uu)
+}
+
+// handlePatchUser applies the patch on the request body (see applyPatch) to the user with the <id> passed on the
+// request url path, if it matches the If-Match header (see checkIfMatch), and updates only the fields that changed,
+// if they're valid, like handleUpdateUser
+func (ur *UserResource) handlePatchUser(c *gin.Context) {
+	id := c.GetInt("id")
+	u, err := ur.Store.GetUserBy("id", id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errUserIdNotFound(id))
+		return
+	}
+	if _, ok := checkIfMatch(c, u.Version); !ok {
+		return
+	}
+	var patched model.User
+	changed, ok := applyPatch(c, u, &patched, errUserCreateInvalidFields, userPatchFields...)
+	if !ok {
+		return
+	}
+
+	var uu model.UserUpdate
+	for _, field := range changed {
+		switch field {
+		case "first_name":
+			uu.FirstName = &patched.FirstName
+		case "last_name":
+			uu.LastName = &patched.LastName
+		case "email":
+			uu.Email = &patched.Email
+		case "active":
+			uu.Active = &patched.Active
+		case "role":
+			uu.Role = &patched.Role
+		}
+	}
+	if err := validateFields(uu); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, errUserCreateInvalidFields)
+		return
+	}
+	ur.updateUser(c, uu)
+}
+
+// handleSetUserActive returns a handler that activates or deactivates (depending on <active>) the user with the <id>
+// passed on the request url path. Deactivated users can't sign in, and are signed out of every session
+func (ur *UserResource) handleSetUserActive(active bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ur.updateUser(c, model.UserUpdate{Active: &active})
+	}
+}
+
+// handleDeleteUser moves the user with the <id> passed on the request url path to the trash, if it matches the If-Match
+// header (see checkIfMatch), signing it out of every session
+func (ur *UserResource) handleDeleteUser(c *gin.Context) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	if id == access.UserID {
+		c.JSON(http.StatusConflict, errUserSelfChange)
+		return
+	}
+	u, err := ur.Store.GetUserBy("id", id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errUserIdNotFound(id))
+		return
+	}
+	version, ok := checkIfMatch(c, u.Version)
+	if !ok {
+		return
+	}
+	if err := ur.Store.DeleteUser(id, version); err != nil {
+		if errors.Is(err, model.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, errPreconditionFailed)
+			return
+		}
+		c.JSON(http.StatusNotFound, errUserIdNotFound(id))
+		return
+	}
+	if err := ur.Store.DeleteUserAccesses(id); err != nil {
+		logging.Logger.Errorln("[API] Failed to revoke sessions of deleted user", err)
+	}
+	c.JSON(http.StatusNoContent, "")
+}
+
+// handleGetDeletedUsers returns a page of the users on the trash, until they're purged (see extractPage for
+// pagination and sorting)
+func (ur *UserResource) handleGetDeletedUsers(c *gin.Context) {
+	page, ok := extractPage(c, "id", "first_name", "last_name", "email", "deleted_at")
+	if !ok {
+		return
+	}
+
+	users, total, err := ur.Store.GetDeletedUsers(page)
+	if err != nil {
+		logging.Logger.Errorln("[API] Failed to get deleted users", err)
+	}
+
+	setPageHeaders(c, page, total)
+	c.JSON(http.StatusOK, users)
+}
+
+// handleRestoreUser takes the user with the <id> passed on the request url path out of the trash. It has to sign in
+// again, since its sessions were revoked when it was deleted
+func (ur *UserResource) handleRestoreUser(c *gin.Context) {
+	id := c.GetInt("id")
+
+	u, err := ur.Store.RestoreUser(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errUserNotInTrash(id))
+		return
+	}
+	setETag(c, u.Version)
+	c.JSON(http.StatusOK, u)
+}
+
+// updateUser applies <uu> to the user with the <id> passed on the request url path, and persists the changed fields,
+// if it matches the If-Match header (see checkIfMatch). Admins can't deactivate or demote themselves, so that at
+// least one admin is left
+func (ur *UserResource) updateUser(c *gin.Context, uu model.UserUpdate) {
+	access, ok := requireAccess(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetInt("id")
+	u, err := ur.Store.GetUserBy("id", id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errUserIdNotFound(id))
+		return
+	}
+	version, ok := checkIfMatch(c, u.Version)
+	if !ok {
+		return
+	}
+
+	columns := uu.Apply(&u)
+	if len(columns) == 0 {
+		setETag(c, u.Version)
+		c.JSON(http.StatusOK, u)
+		return
+	}
+	if id == access.UserID && (!u.Active || u.Role != model.RoleAdmin) {
+		c.JSON(http.StatusConflict, errUserSelfChange)
+		return
+	}
+	if uu.Email != nil {
+		if existing, err := ur.Store.GetUserByEmailIncludingTrash(u.Email); err == nil && existing.ID != id {
+			c.JSON(http.StatusConflict, errUserEmailTaken)
+			return
+		}
+	}
+
+	u.Version = version
+	updatedUser, err := ur.Store.UpdateUser(u, columns...)
+	if err != nil {
+		if errors.Is(err, model.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, errPreconditionFailed)
+			return
+		}
+		c.JSON(http.StatusBadRequest, errUserUpdateGeneric)
+		return
+	}
+	if !updatedUser.Active {
+		if err = ur.Store.DeleteUserAccesses(id); err != nil {
+			logging.Logger.Errorln("[API] Failed to revoke sessions of deactivated user", err)
+		}
+	}
+	setETag(c, updatedUser.Version)
+
+	c.JSON(http.StatusOK, updatedUser)
 }
@@ -0,0 +1,67 @@
+package blob
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when there is no blob stored under a key
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey is returned when a key has characters other than letters, digits, "-" and "_"
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Object is the content of a stored blob, that can be read from any position (to serve range requests)
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// BlobStore stores binary content (blobs), such as task attachments, under unique keys
+type BlobStore interface {
+	// Put stores the content read from <r> under <key>. If reading fails, nothing is stored
+	Put(key string, r io.Reader) error
+	// Get opens the blob stored under <key>, or returns ErrNotFound if there is none
+	Get(key string) (Object, error)
+	// Delete removes the blob stored under <key>. Deleting a blob that doesn't exist isn't an error
+	Delete(key string) error
+}
+
+// NewBlobStore creates the BlobStore selected by the BLOB_STORE configuration. Only "local" is supported, which
+// stores blobs as files on BLOB_DIR
+func NewBlobStore() (BlobStore, error) {
+	switch strings.ToLower(viper.GetString("BLOB_STORE")) {
+	case "local", "":
+		return NewLocalBlobStore(viper.GetString("BLOB_DIR"))
+	default:
+		return nil, fmt.Errorf("unknown blob store: %v", viper.GetString("BLOB_STORE"))
+	}
+}
+
+// NewKey returns a new random key, to store a blob under
+func NewKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validKey checks if a key is safe to use as a file name or object name, with only letters, digits, "-" and "_"
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > 128 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package blob
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LocalBlobStore is a BlobStore that stores each blob as a file on Dir, named after its key
+type LocalBlobStore struct {
+	Dir string
+}
+
+// NewLocalBlobStore returns a LocalBlobStore that stores blobs on <dir>, creating it if it doesn't exist
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{Dir: dir}, nil
+}
+
+// Put writes the content to a temporary file first, and only renames it to the key once it's fully written, so that
+// partial uploads are never visible
+func (s *LocalBlobStore) Put(key string, r io.Reader) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	file, err := ioutil.TempFile(s.Dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(s.Dir, key))
+}
+
+func (s *LocalBlobStore) Get(key string) (Object, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	file, err := os.Open(filepath.Join(s.Dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	if err := os.Remove(filepath.Join(s.Dir, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
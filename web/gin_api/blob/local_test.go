@@ -0,0 +1,64 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// failingReader returns some content and then fails
+type failingReader struct {
+	read bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection reset")
+	}
+	r.read = true
+	return copy(p, "partial"), nil
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put("report", strings.NewReader("quarterly report")); err != nil {
+		t.Fatalf("Expected the blob to be stored, but got %v", err)
+	}
+	object, err := store.Get("report")
+	if err != nil {
+		t.Fatalf("Expected the blob to exist, but got %v", err)
+	}
+	object.Seek(10, io.SeekStart)
+	content, _ := ioutil.ReadAll(object)
+	object.Close()
+	if string(content) != "report" {
+		t.Errorf("Expected to read %q from the blob, but got %q", "report", content)
+	}
+
+	if err := store.Put("partial", &failingReader{}); err == nil {
+		t.Errorf("Expected storing a failed upload to fail")
+	}
+	if _, err := store.Get("partial"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a failed upload not to be stored, but got %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the stored blob to be on the directory, but got %v files", len(files))
+	}
+
+	if err := store.Delete("report"); err != nil {
+		t.Errorf("Expected the blob to be deleted, but got %v", err)
+	}
+	if err := store.Delete("report"); err != nil {
+		t.Errorf("Expected deleting a missing blob not to fail, but got %v", err)
+	}
+	if _, err := store.Get("../report"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected a key with a path to be invalid, but got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/jomifepe/gin_api/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	promoteCmd = &cobra.Command{
+		Use:   "promote [email]",
+		Short: "Grants the admin role to an existing user",
+		Long: `Grants the admin role to the user with the specified email, allowing it to manage other users. 
+Since only admins can do it through the API, this is how the first admin is created.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logging.NewLogger()
+			userStore := storage.NewUserStore(storage.ConfigurePostgresDB())
+
+			user, err := userStore.GetUserBy("email", args[0])
+			if err != nil {
+				logging.Logger.WithFields(logrus.Fields{
+					"email": args[0],
+				}).Fatalln("[CMD] No user with the specified email was found")
+			}
+			if err = userStore.UpdateUserRole(user.ID, model.RoleAdmin); err != nil {
+				logging.Logger.WithFields(logrus.Fields{
+					"email": args[0],
+					"error": err,
+				}).Fatalln("[CMD] Failed to promote user")
+			}
+			fmt.Printf("%v is now an admin\n", user.Email)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+}
@@ -41,7 +41,49 @@ func init() {
 	viper.SetDefault("POSTGRES_DB", "go_test")
 	viper.SetDefault("DATABASE_HOST", "localhost")
 	viper.SetDefault("DATABASE_PORT", "5432")
-	viper.SetDefault("JWT_ACCESS_SECRET", util.GetRandStringBytes(64))
+	viper.SetDefault("JWT_SIGNING_KEY_FILE", "jwt_signing_key.pem")
+	viper.SetDefault("JWT_VERIFICATION_KEY_FILES", "")
+	viper.SetDefault("JWT_ACCESS_TTL", "15m")
+	viper.SetDefault("JWT_REFRESH_TTL", "168h")
+	viper.SetDefault("JWT_ISSUER", "gin_api")
+	viper.SetDefault("JWT_AUDIENCE", "gin_api")
+	viper.SetDefault("JWT_CLOCK_SKEW", "30s")
+	viper.SetDefault("LOGIN_ATTEMPTS_STORE", "postgres")
+	viper.SetDefault("LOGIN_FAILURE_WINDOW", "15m")
+	viper.SetDefault("LOGIN_DELAY_THRESHOLD", 3)
+	viper.SetDefault("LOGIN_DELAY_BASE", "1s")
+	viper.SetDefault("LOGIN_DELAY_MAX", "30s")
+	viper.SetDefault("LOGIN_MAX_ACCOUNT_FAILURES", 5)
+	viper.SetDefault("LOGIN_MAX_IP_FAILURES", 20)
+	viper.SetDefault("LOGIN_LOCKOUT_DURATION", "15m")
+	viper.SetDefault("MFA_TOKEN_TTL", "5m")
+	viper.SetDefault("TOTP_ISSUER", "gin_api")
+	viper.SetDefault("API_KEY_DEFAULT_TTL", "2160h")
+	viper.SetDefault("API_KEY_MAX_TTL", "8760h")
+	viper.SetDefault("PAGE_DEFAULT_LIMIT", 20)
+	viper.SetDefault("PAGE_MAX_LIMIT", 100)
+	viper.SetDefault("RECURRENCE_INTERVAL", "1m")
+	viper.SetDefault("PROJECT_INVITATION_TTL", "168h")
+	viper.SetDefault("BLOB_STORE", "local")
+	viper.SetDefault("BLOB_DIR", "attachments")
+	viper.SetDefault("ATTACHMENT_MAX_SIZE", 10<<20)
+	viper.SetDefault("ATTACHMENT_TYPES", "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain,application/zip")
+	viper.SetDefault("TRASH_RETENTION", "720h")
+	viper.SetDefault("TRASH_PURGE_INTERVAL", "1h")
+	viper.SetDefault("IDEMPOTENCY_STORE", "postgres")
+	viper.SetDefault("IDEMPOTENCY_KEY_TTL", "24h")
+	viper.SetDefault("IDEMPOTENCY_LOCK_TIMEOUT", "1m")
+	viper.SetDefault("IDEMPOTENCY_MAX_BODY_SIZE", 11<<20)
+	viper.SetDefault("PUBLIC_URL", "http://localhost:3000")
+	viper.SetDefault("EMAIL_VERIFICATION_TTL", "24h")
+	viper.SetDefault("PASSWORD_RESET_TTL", "1h")
+	viper.SetDefault("MAILER", "log")
+	viper.SetDefault("MAILER_DIR", "mail")
+	viper.SetDefault("MAILER_FROM", "gin_api@localhost")
+	viper.SetDefault("SMTP_HOST", "localhost")
+	viper.SetDefault("SMTP_PORT", "25")
+	viper.SetDefault("SMTP_USER", "")
+	viper.SetDefault("SMTP_PASSWORD", "")
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "Path to an environment config file")
 }
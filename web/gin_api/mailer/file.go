@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer is a Mailer that doesn't deliver messages, it writes each one to a new file on Dir instead
+type FileMailer struct {
+	Dir string
+}
+
+// NewFileMailer returns a FileMailer that writes to <dir>, creating it if it doesn't exist
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{Dir: dir}, nil
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	file := filepath.Join(m.Dir, fmt.Sprintf("%v.eml", time.Now().UnixNano()))
+	content := fmt.Sprintf("To: %v\r\nSubject: %v\r\n\r\n%v\r\n", msg.To, msg.Subject, msg.Body)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"to":   msg.To,
+		"file": file,
+	}).Infoln("[MAIL] Message written to file")
+	return nil
+}
@@ -0,0 +1,18 @@
+package mailer
+
+import (
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// LogMailer is a Mailer that doesn't deliver messages, it writes their recipient and subject to the logger instead.
+// Their body is left out, since it can have tokens that give access to accounts (use FileMailer to read it)
+type LogMailer struct{}
+
+func (m *LogMailer) Send(msg Message) error {
+	logging.Logger.WithFields(logrus.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+	}).Warnln("[MAIL] Message not delivered, logging it instead")
+	return nil
+}
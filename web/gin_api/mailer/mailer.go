@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+// Message is an email to be delivered to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer creates the Mailer selected by the MAILER configuration:
+// "log" writes messages to the logger, "file" writes them to files on MAILER_DIR (both meant for local development)
+// and "smtp" sends them through the SMTP server on SMTP_HOST:SMTP_PORT
+func NewMailer() (Mailer, error) {
+	switch strings.ToLower(viper.GetString("MAILER")) {
+	case "log", "":
+		return &LogMailer{}, nil
+	case "file":
+		return NewFileMailer(viper.GetString("MAILER_DIR"))
+	case "smtp":
+		return &SMTPMailer{
+			Host:     viper.GetString("SMTP_HOST"),
+			Port:     viper.GetString("SMTP_PORT"),
+			Username: viper.GetString("SMTP_USER"),
+			Password: viper.GetString("SMTP_PASSWORD"),
+			From:     viper.GetString("MAILER_FROM"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer: %v", viper.GetString("MAILER"))
+	}
+}
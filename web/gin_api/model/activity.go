@@ -0,0 +1,137 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	TaskActionCreate  = "create"
+	TaskActionUpdate  = "update"
+	TaskActionToggle  = "toggle"
+	TaskActionDelete  = "delete"
+	TaskActionRestore = "restore"
+)
+
+// TaskComment - A comment left on a task by one of the users that can read it
+type TaskComment struct {
+	ID        int       `json:"id"`
+	TaskID    int       `json:"task_id" gorm:"index"`
+	UserID    int       `json:"user_id"`
+	Body      string    `json:"body" validate:"required,min=1,max=10000" binding:"required,min=1,max=10000" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TaskActivity - An entry of the activity log of a task, recording an action performed on it by a user (the actor),
+// along with the fields it changed. Entries are never updated or deleted
+type TaskActivity struct {
+	ID        int         `json:"id"`
+	TaskID    int         `json:"task_id" gorm:"index"`
+	ActorID   int         `json:"actor_id"`
+	Action    string      `json:"action"`
+	Changes   TaskChanges `json:"changes" gorm:"type:text"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// FieldChange holds the previous and new values of a field
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// TaskChanges are the changes made to the fields of a task, by their json name. They're stored as JSON
+type TaskChanges map[string]FieldChange
+
+// Value encodes the changes as JSON, to be stored on the database
+func (c TaskChanges) Value() (driver.Value, error) {
+	if c == nil {
+		c = TaskChanges{}
+	}
+	encoded, err := json.Marshal(c)
+	return string(encoded), err
+}
+
+// Scan decodes the changes from the JSON stored on the database
+func (c *TaskChanges) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*c = TaskChanges{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("can't scan %T into task changes", value)
+	}
+}
+
+// DiffTasks returns the fields that differ between two versions of a task. Tags are compared by id, and the ids of
+// the tags and blockers are sorted. An empty <before> returns every field of <after> that is set
+func DiffTasks(before Task, after Task) TaskChanges {
+	var (
+		changes = TaskChanges{}
+		from    = taskFields(before)
+		to      = taskFields(after)
+	)
+	for field, value := range to {
+		if !reflect.DeepEqual(from[field], value) {
+			changes[field] = FieldChange{From: from[field], To: value}
+		}
+	}
+	return changes
+}
+
+// taskFields returns the fields of a task that are recorded on its activity log, by their json name. Fields that
+// aren't set are nil
+func taskFields(t Task) map[string]interface{} {
+	fields := map[string]interface{}{
+		"description": nil,
+		"completed":   t.Completed,
+		"project_id":  nil,
+		"parent_id":   nil,
+		"due_at":      nil,
+		"priority":    t.Priority,
+		"notes":       nil,
+		"recurrence":  nil,
+		"tags":        nil,
+		"blocked_by":  nil,
+	}
+	if len(t.Description) > 0 {
+		fields["description"] = t.Description
+	}
+	if t.ProjectID != nil {
+		fields["project_id"] = *t.ProjectID
+	}
+	if t.ParentID != nil {
+		fields["parent_id"] = *t.ParentID
+	}
+	if t.DueAt != nil {
+		fields["due_at"] = t.DueAt.UTC().Format(time.RFC3339Nano)
+	}
+	if len(t.Notes) > 0 {
+		fields["notes"] = t.Notes
+	}
+	if len(t.Recurrence) > 0 {
+		fields["recurrence"] = t.Recurrence
+	}
+	if len(t.Tags) > 0 {
+		ids := make([]int, len(t.Tags))
+		for i, tag := range t.Tags {
+			ids[i] = tag.ID
+		}
+		sort.Ints(ids)
+		fields["tags"] = ids
+	}
+	if len(t.BlockedBy) > 0 {
+		ids := append([]int{}, t.BlockedBy...)
+		sort.Ints(ids)
+		fields["blocked_by"] = ids
+	}
+	return fields
+}
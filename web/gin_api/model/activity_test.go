@@ -0,0 +1,47 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffTasks(t *testing.T) {
+	due := time.Date(2021, time.March, 1, 8, 0, 0, 0, time.UTC)
+	before := Task{ID: 1, Description: "Water the plants", Priority: PriorityLow, Tags: []Tag{{ID: 3}, {ID: 2}},
+		BlockedBy: []int{5}}
+	after := before
+	after.Description = "Water the garden"
+	after.DueAt = &due
+	after.Tags = []Tag{{ID: 2}, {ID: 3}}
+	after.BlockedBy = []int{}
+
+	expected := TaskChanges{
+		"description": {From: "Water the plants", To: "Water the garden"},
+		"due_at":      {From: nil, To: "2021-03-01T08:00:00Z"},
+		"blocked_by":  {From: []int{5}, To: nil},
+	}
+	if changes := DiffTasks(before, after); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected the changes to be %v, but got %v", expected, changes)
+	}
+
+	if changes := DiffTasks(before, before); len(changes) != 0 {
+		t.Errorf("Expected no changes, but got %v", changes)
+	}
+	if changes := DiffTasks(Task{}, before); len(changes) != 4 || changes["priority"].To != PriorityLow {
+		t.Errorf("Expected the changes to set the task fields, but got %v", changes)
+	}
+}
+
+func TestTaskChangesValue(t *testing.T) {
+	changes := TaskChanges{"completed": {From: false, To: true}}
+	value, err := changes.Value()
+	if err != nil {
+		t.Fatalf("Expected the changes to be encoded, but got %v", err)
+	}
+
+	var decoded TaskChanges
+	if err := decoded.Scan([]byte(value.(string))); err != nil || !reflect.DeepEqual(decoded, changes) {
+		t.Errorf("Expected the changes to be decoded as %v, but got %v (%v)", changes, decoded, err)
+	}
+}
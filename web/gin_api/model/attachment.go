@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// Attachment - A file attached to a task by one of the users that can write it. Its content is kept on a blob store,
+// under BlobKey
+type Attachment struct {
+	ID          int       `json:"id"`
+	TaskID      int       `json:"task_id" gorm:"index"`
+	UserID      int       `json:"user_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	BlobKey     string    `json:"-" gorm:"uniqueIndex"`
+	CreatedAt   time.Time `json:"created_at"`
+}
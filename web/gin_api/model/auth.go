@@ -0,0 +1,97 @@
+package model
+
+import (
+	"github.com/lib/pq"
+	"time"
+)
+
+// RefreshRequest holds the refresh token sent to renew an access token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// ChangePasswordRequest holds the current password of a user, to confirm its identity, and the new one
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=6,max=72" binding:"required,min=6,max=72"`
+}
+
+// ForgotPasswordRequest holds the email address of a user that wants to reset its password
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email" binding:"required,email"`
+}
+
+// ResetPasswordRequest holds a password reset token, sent to the user by email, and the new password
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=6,max=72" binding:"required,min=6,max=72"`
+}
+
+// PasswordReset - A single-use password reset token issued to a user. Only the token hash is stored
+type PasswordReset struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RecoveryCode - A single-use code that replaces a TOTP code when the user loses access to its authenticator.
+// Only the code hash is stored
+type RecoveryCode struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id" gorm:"index"`
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TOTPEnrollment holds a new TOTP secret, and its otpauth URI, to be added to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPConfirmation holds a code generated by the authenticator app, to confirm a TOTP enrollment
+type TOTPConfirmation struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TOTPDisable holds the password and a TOTP or recovery code of a user, to confirm its identity before disabling
+// two-factor authentication
+type TOTPDisable struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// SecondFactorRequest holds the token received after signing in with a password, and the TOTP or recovery code
+// that completes the sign in
+type SecondFactorRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+	Device   string `json:"device" validate:"max=124"`
+}
+
+// APIKey - A named, scoped and expiring key that authenticates requests of a user in place of a jwt token, meant for
+// scripts and CI jobs. Only the key hash is stored, along with a hint of the key to tell keys apart
+type APIKey struct {
+	ID         int            `json:"id"`
+	UserID     int            `json:"user_id" gorm:"index"`
+	Name       string         `json:"name"`
+	Hint       string         `json:"hint"`
+	KeyHash    string         `json:"-" gorm:"uniqueIndex"`
+	Scopes     pq.StringArray `json:"scopes" gorm:"type:text[]"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Key        string         `json:"key,omitempty" gorm:"-"`
+}
+
+// APIKeyRequest holds the details of a new API key. If the expiration date isn't specified, it's set to
+// API_KEY_DEFAULT_TTL from now
+type APIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=124" binding:"required,max=124"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=users:read users:write tasks:read tasks:write" binding:"required,min=1,dive,oneof=users:read users:write tasks:read tasks:write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "errors"
+
+// ErrBulkAborted is returned for the operations of a transactional bulk request that weren't applied, or were rolled
+// back, because another one failed
+var ErrBulkAborted = errors.New("bulk operation aborted")
+
+// TaskOperation - One of the operations of a bulk request: creating a task, or updating, toggling or deleting the
+// existing task with the id <ID>
+type TaskOperation struct {
+	// Op is TaskActionCreate, TaskActionUpdate, TaskActionToggle or TaskActionDelete
+	Op string `json:"op" binding:"required,oneof=create update toggle delete"`
+	ID int    `json:"id" binding:"omitempty,min=1"`
+	// Version, unless it's 0, must still be the version of the task, like the If-Match header of single requests
+	Version int `json:"version" binding:"omitempty,min=1"`
+	// Task is the task to create, or the task to update, with all its fields (like on PUT /tasks/{id})
+	Task *Task `json:"task"`
+}
+
+// TaskBulk - A list of operations on tasks, applied on a single transaction, unless <BestEffort>, in which case each
+// one is applied independently. Operations are validated one by one, so that an invalid one only fails itself
+type TaskBulk struct {
+	BestEffort bool            `json:"best_effort"`
+	Operations []TaskOperation `json:"operations" binding:"required,min=1,max=500"`
+}
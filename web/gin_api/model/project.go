@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	ProjectRoleOwner  = "owner"
+	ProjectRoleEditor = "editor"
+	ProjectRoleViewer = "viewer"
+)
+
+var (
+	// ErrLastProjectOwner is returned when removing or demoting the only owner of a project
+	ErrLastProjectOwner = errors.New("project must have an owner")
+)
+
+// Project - A list of tasks shared by its members
+type Project struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name" validate:"required,min=1,max=124" binding:"required,min=1,max=124"`
+	Description string    `json:"description" validate:"max=1024" binding:"max=1024"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Role is the role of the current user on the project
+	Role string `json:"role,omitempty" gorm:"-"`
+}
+
+// ProjectMember - A user that can access the tasks of a project. Viewers can read them, editors can also write them
+// and owners can also manage the project and its members
+type ProjectMember struct {
+	ProjectID int       `json:"project_id" gorm:"primaryKey;autoIncrement:false"`
+	UserID    int       `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+	Role      string    `json:"role" validate:"required,oneof=owner editor viewer" binding:"required,oneof=owner editor viewer"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProjectMemberDetails - A project member along with the user details
+type ProjectMemberDetails struct {
+	ProjectMember
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// ProjectMemberUpdate holds the new role of a project member
+type ProjectMemberUpdate struct {
+	Role string `json:"role" validate:"required,oneof=owner editor viewer" binding:"required,oneof=owner editor viewer"`
+}
+
+// ProjectInvitation - An invitation to join a project with a role, sent by email. Only the token hash is stored
+type ProjectInvitation struct {
+	ID          int        `json:"id"`
+	ProjectID   int        `json:"project_id" gorm:"index"`
+	Email       string     `json:"email" validate:"required,email" binding:"required,email"`
+	Role        string     `json:"role" validate:"required,oneof=owner editor viewer" binding:"required,oneof=owner editor viewer"`
+	InvitedByID int        `json:"invited_by_id"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AcceptInvitationRequest holds the token of a project invitation, sent by email
+type AcceptInvitationRequest struct {
+	Token string `json:"token" validate:"required" binding:"required"`
+}
@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+// Page holds the pagination and sorting of a listing. Sort is the name of the column to sort by
+type Page struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Desc   bool
+}
+
+// TaskQuery holds the filters, pagination and sorting of a task listing. Nil or empty filters aren't applied
+type TaskQuery struct {
+	Page
+	// ProjectID matches the tasks of a project, or the personal tasks of the user if 0
+	ProjectID     *int
+	Completed     *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Description matches the tasks whose description contains it, ignoring case
+	Description string
+	// Tag matches the tasks with a tag of this name
+	Tag      string
+	Priority *int
+	// Overdue matches the uncompleted tasks whose due date has passed, or every other task if false
+	Overdue *bool
+}
+
+// UserQuery holds the filters, pagination and sorting of a user listing. Nil or empty filters aren't applied
+type UserQuery struct {
+	Page
+	// Email matches the users whose email contains it, ignoring case
+	Email string
+	// Name matches the users whose first or last name contain it, ignoring case
+	Name   string
+	Active *bool
+}
+
+// TaskSearch holds the text of a task search, and the pagination and sorting of its results. Results are sorted by
+// relevance when Sort is "rank"
+type TaskSearch struct {
+	Page
+	Query string
+	// ProjectID matches the tasks of a project, or the personal tasks of the user if 0
+	ProjectID *int
+}
@@ -1,20 +1,104 @@
 package model
 
 import (
+	"errors"
 	"fmt"
+	"gorm.io/gorm"
 	"time"
 )
 
-// Task - Information about a task to be done, and if it's completed or not
+const (
+	PriorityNone   = 0
+	PriorityLow    = 1
+	PriorityMedium = 2
+	PriorityHigh   = 3
+)
+
+var (
+	// ErrTaskLinkNotFound is returned when the parent or a blocker of a task isn't one of the tasks of its user
+	ErrTaskLinkNotFound = errors.New("linked task not found")
+	// ErrTaskCycle is returned when the parent or blockers of a task would make it its own ancestor or blocker
+	ErrTaskCycle = errors.New("task links would create a cycle")
+	// ErrTaskBlocked is returned when completing a task that is blocked by uncompleted tasks
+	ErrTaskBlocked = errors.New("task is blocked by uncompleted tasks")
+	// ErrVersionConflict is returned when writing a task or user that was changed since the version it was read with
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+// Task - Information about a task to be done, and if it's completed or not. Tasks belong to the user that created them,
+// unless they're on a project, in which case they're shared by its members
 type Task struct {
-	ID          int       `json:"id"`
-	Description string    `json:"description" validate:"required,min=1,max=124" binding:"required"`
-	Completed   bool      `json:"completed"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id" gorm:"index"`
+	ProjectID   *int       `json:"project_id" gorm:"index" binding:"omitempty,min=1"`
+	Description string     `json:"description" validate:"required,min=1,max=124" binding:"required"`
+	Completed   bool       `json:"completed"`
+	ParentID    *int       `json:"parent_id" gorm:"index" binding:"omitempty,min=1"`
+	DueAt       *time.Time `json:"due_at"`
+	Priority    int        `json:"priority" validate:"min=0,max=3" binding:"min=0,max=3" gorm:"default:0"`
+	Notes       string     `json:"notes" validate:"max=10000" binding:"max=10000" gorm:"type:text"`
+	Tags        []Tag      `json:"tags" gorm:"many2many:task_tags"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// Recurrence is "daily", "weekly", "monthly" or a cron expression. Once a recurring task is completed or due,
+	// its next occurrence is created
+	Recurrence string `json:"recurrence" binding:"max=124"`
+	// RecurrenceDay is the day of the month the occurrences of a monthly task are due on, even after falling on the
+	// last day of a shorter month. It's 0 until the task recurs, and is reset when its due day or recurrence change
+	RecurrenceDay int `json:"-"`
+	// RecurredFromID is the id of the previous occurrence of a recurring task
+	RecurredFromID *int `json:"recurred_from_id" gorm:"index"`
+	// RecurredAt is when the next occurrence of a recurring task was created
+	RecurredAt *time.Time `json:"-"`
+
+	// DetachedFromID is the id of the parent of a subtask while that parent is on the trash, so that it's reattached to
+	// it when it's restored. It's cleared when the subtask is moved to another parent
+	DetachedFromID *int `json:"-" gorm:"index"`
+
+	// Version is incremented every time the task is changed, so that clients can detect concurrent changes
+	Version int `json:"version" gorm:"not null;default:1"`
+	// DeletedAt is when the task was moved to the trash. Trashed tasks are left out of every query, unless unscoped
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+
+	// TagIDs are the ids of the tags to set on the task, when creating or updating it. Nil leaves them unchanged
+	TagIDs []int `json:"tag_ids,omitempty" gorm:"-" binding:"omitempty,max=32,dive,min=1"`
+	// BlockedBy are the ids of the tasks that must be completed before this one. When creating or updating the task,
+	// nil leaves them unchanged
+	BlockedBy []int `json:"blocked_by" gorm:"-" binding:"omitempty,max=32,dive,min=1"`
 }
 
 func (u Task) String() string {
-	return fmt.Sprintf("Task #%v:\nDescription: %v\nCompleted: %v\nCreated: %v\nUpdated:%v\n",
-		u.ID, u.Description, u.Completed, u.CreatedAt, u.UpdatedAt)
-}
\ No newline at end of file
+	return fmt.Sprintf("Task #%v:\nUser: %v\nDescription: %v\nCompleted: %v\nDue: %v\nPriority: %v\nCreated: %v\nUpdated:%v\n",
+		u.ID, u.UserID, u.Description, u.Completed, u.DueAt, u.Priority, u.CreatedAt, u.UpdatedAt)
+}
+
+// TaskSearchResult - A task that matches a search, along with its relevance and its description with the matching
+// terms highlighted (wrapped in <mark> tags, with the rest of the description HTML escaped)
+type TaskSearchResult struct {
+	Task
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// TaskTree - A task along with its subtasks, recursively
+type TaskTree struct {
+	Task
+	Subtasks []*TaskTree `json:"subtasks"`
+}
+
+// TaskDependency - A task (TaskID) that can't be completed before another one (BlockedByID) is
+type TaskDependency struct {
+	TaskID      int `gorm:"primaryKey;autoIncrement:false"`
+	BlockedByID int `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// Tag - A label that users can put on their tasks, to group them. Tags belong to the user that created them, and
+// their names are unique per user
+type Tag struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id" gorm:"uniqueIndex:idx_tags_user_name"`
+	Name      string    `json:"name" validate:"required,min=1,max=32" binding:"required,min=1,max=32" gorm:"uniqueIndex:idx_tags_user_name"`
+	Color     string    `json:"color" validate:"omitempty,hexcolor" binding:"omitempty,hexcolor"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,10 +1,19 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
 
 type AuthUser struct {
 	Email     string `json:"email" validate:"required,email" gorm:"unique" binding:"required"`
 	Password  string `json:"password,omitempty" validate:"required,min=6,max=72"`
+	Device    string `json:"device" validate:"max=124"`
 }
 
 type User struct {
@@ -14,6 +23,61 @@ type User struct {
 	Email     string `json:"email" validate:"required,email" gorm:"unique" binding:"required"`
 	Password  string `json:"password,omitempty" validate:"required,min=6,max=72"`
 	Active    bool   `json:"active" gorm:"default:true"`
+	Role      string `json:"role" validate:"omitempty,oneof=admin member" binding:"omitempty,oneof=admin member" gorm:"default:member"`
+	Pending   bool   `json:"pending" gorm:"default:false"`
+
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"column:totp_enabled;default:false"`
+	TOTPSecret   string `json:"-" gorm:"column:totp_secret"`
+	TOTPLastStep int64  `json:"-" gorm:"column:totp_last_step;default:0"`
+
+	// Version is incremented every time the user is changed, so that clients can detect concurrent changes
+	Version int `json:"version" gorm:"not null;default:1"`
+	// DeletedAt is when the user was moved to the trash. Trashed users are left out of every query, unless unscoped
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// UserUpdate holds the fields of a user to be updated. Fields that aren't specified are left unchanged
+type UserUpdate struct {
+	FirstName *string `json:"first_name" validate:"omitempty,alpha,min=1,max=1024" binding:"omitempty,min=1,max=1024"`
+	LastName  *string `json:"last_name" validate:"omitempty,alpha,min=1,max=1024" binding:"omitempty,min=1,max=1024"`
+	Email     *string `json:"email" validate:"omitempty,email" binding:"omitempty,email"`
+	Active    *bool   `json:"active"`
+	Role      *string `json:"role" validate:"omitempty,oneof=admin member" binding:"omitempty,oneof=admin member"`
+}
+
+// Apply sets the specified fields on <u>, and returns the names of the columns that were changed
+func (uu UserUpdate) Apply(u *User) []string {
+	var columns []string
+	if uu.FirstName != nil && *uu.FirstName != u.FirstName {
+		u.FirstName, columns = *uu.FirstName, append(columns, "first_name")
+	}
+	if uu.LastName != nil && *uu.LastName != u.LastName {
+		u.LastName, columns = *uu.LastName, append(columns, "last_name")
+	}
+	if uu.Email != nil && *uu.Email != u.Email {
+		u.Email, columns = *uu.Email, append(columns, "email")
+	}
+	if uu.Active != nil && *uu.Active != u.Active {
+		u.Active, columns = *uu.Active, append(columns, "active")
+	}
+	if uu.Role != nil && *uu.Role != u.Role {
+		u.Role, columns = *uu.Role, append(columns, "role")
+	}
+	return columns
+}
+
+// Registration holds the details sent by someone signing up. The resulting user stays pending until its email
+// address is verified
+type Registration struct {
+	FirstName string `json:"first_name" validate:"required,alpha,min=1,max=1024" binding:"required,min=1,max=1024"`
+	LastName  string `json:"last_name" validate:"required,alpha,min=1,max=1024" binding:"required,min=1,max=1024"`
+	Email     string `json:"email" validate:"required,email" binding:"required,email"`
+	Password  string `json:"password" validate:"required,min=6,max=72" binding:"required,min=6,max=72"`
+}
+
+// VerificationRequest holds the email address of a pending user that asked for a new verification email
+type VerificationRequest struct {
+	Email string `json:"email" validate:"required,email" binding:"required,email"`
 }
 
 func (u User) String() string {
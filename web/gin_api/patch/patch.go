@@ -0,0 +1,375 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch is malformed, or isn't a valid patch of its kind
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrConflict is returned when a patch can't be applied to a document, because one of the locations it refers to
+	// doesn't exist, or one of its tests failed
+	ErrConflict = errors.New("patch conflicts with the document")
+)
+
+// MergePatch applies a JSON merge patch (RFC 7396) to the JSON document <doc>, and returns the resulting document.
+// The patch members replace the ones of the document, recursively, and null members remove them
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return json.Marshal(merge(target, changes))
+}
+
+// merge applies the merge patch <changes> to <target>, which is modified
+func merge(target interface{}, changes interface{}) interface{} {
+	members, ok := changes.(map[string]interface{})
+	if !ok {
+		return changes
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for name, value := range members {
+		if value == nil {
+			delete(object, name)
+		} else {
+			object[name] = merge(object[name], value)
+		}
+	}
+	return object
+}
+
+// JSONPatch applies a JSON patch (RFC 6902), a list of add, remove, replace, move, copy and test operations, to the
+// JSON document <doc>, and returns the resulting document. The operations are applied in order, and if any of them
+// fails, none are
+func JSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	var operations []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	for i, operation := range operations {
+		if target, err = apply(target, operation); err != nil {
+			return nil, fmt.Errorf("operation %v: %w", i, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+// apply applies a single JSON patch operation to <target>, which may be modified, and returns the resulting document
+func apply(target interface{}, operation map[string]json.RawMessage) (interface{}, error) {
+	var op string
+	if err := member(operation, "op", &op); err != nil {
+		return nil, err
+	}
+	path, err := pointer(operation, "path")
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "add", "replace", "test":
+		raw, ok := operation["value"]
+		if !ok {
+			return nil, fmt.Errorf("%w: %v has no value", ErrInvalidPatch, op)
+		}
+		value, err := decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		switch op {
+		case "add":
+			return add(target, path, value)
+		case "replace":
+			if target, _, err = remove(target, path); err != nil {
+				return nil, err
+			}
+			return add(target, path, value)
+		}
+		current, err := get(target, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, fmt.Errorf("%w: test of %q failed", ErrConflict, operation["path"])
+		}
+		return target, nil
+	case "remove":
+		target, _, err = remove(target, path)
+		return target, err
+	case "move", "copy":
+		from, err := pointer(operation, "from")
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: can't move a location into one of its children", ErrInvalidPatch)
+			}
+			target, value, err = remove(target, from)
+		} else {
+			value, err = get(target, from)
+			value = deepCopy(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(target, path, value)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op)
+	}
+}
+
+// add sets <value> on the location <path> of <node>: a member of an object, replacing it if it exists, or an
+// element of an array, shifting the following ones ("-" appends it). Returns the resulting node
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, notFound(token)
+		}
+		child, err := add(child, rest, value)
+		n[token] = child
+		return n, err
+	case []interface{}:
+		if len(rest) == 0 {
+			i := len(n)
+			if token != "-" {
+				var err error
+				if i, err = index(token, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		i, err := index(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		n[i], err = add(n[i], rest, value)
+		return n, err
+	default:
+		return nil, notFound(token)
+	}
+}
+
+// remove removes the location <path> of <node>, which must exist, returning the resulting node and the removed value
+func remove(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, node, nil
+	}
+	token, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, notFound(token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		child, removed, err := remove(child, rest)
+		n[token] = child
+		return n, removed, err
+	case []interface{}:
+		i, err := index(token, len(n)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		child, removed, err := remove(n[i], rest)
+		n[i] = child
+		return n, removed, err
+	default:
+		return nil, nil, notFound(token)
+	}
+}
+
+// get returns the value on the location <path> of <node>, which must exist
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, notFound(token)
+			}
+			node = child
+		case []interface{}:
+			i, err := index(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, notFound(token)
+		}
+	}
+	return node, nil
+}
+
+// index parses the array index <token>, that can't be greater than <max>
+func index(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') || strings.HasPrefix(token, "+") {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	if i > max {
+		return 0, notFound(token)
+	}
+	return i, nil
+}
+
+// notFound returns an ErrConflict error for a location that doesn't exist
+func notFound(token string) error {
+	return fmt.Errorf("%w: %q doesn't exist", ErrConflict, token)
+}
+
+// pointer parses the JSON pointer (RFC 6901) on the member <name> of a patch operation into its reference tokens
+func pointer(operation map[string]json.RawMessage, name string) ([]string, error) {
+	var p string
+	if err := member(operation, name, &p); err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("%w: invalid pointer %q", ErrInvalidPatch, p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// member decodes the required string member <name> of a patch operation into <s>
+func member(operation map[string]json.RawMessage, name string, s *string) error {
+	raw, ok := operation[name]
+	if !ok {
+		return fmt.Errorf("%w: operation has no %v", ErrInvalidPatch, name)
+	}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return fmt.Errorf("%w: %v must be a string", ErrInvalidPatch, name)
+	}
+	return nil
+}
+
+// isPrefix returns true if the pointer <prefix> is <path> or one of its ancestors
+func isPrefix(prefix []string, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decode decodes a single JSON value, keeping its numbers as json.Number so that they aren't rounded
+func decode(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// equal returns true if two decoded JSON values are equal. Numbers are compared by value, so 1 and 1.0 are equal
+func equal(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, value := range x {
+			if other, ok := y[name]; !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// deepCopy returns a copy of a decoded JSON value that doesn't share any objects or arrays with it
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			object[name] = deepCopy(member)
+		}
+		return object
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			array[i] = deepCopy(element)
+		}
+		return array
+	default:
+		return value
+	}
+}
@@ -0,0 +1,78 @@
+package patch
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	cases := []struct{ doc, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"a":9007199254740993}`, `{"b":{}}`, `{"a":9007199254740993,"b":{}}`},
+	}
+	for _, c := range cases {
+		got, err := MergePatch([]byte(c.doc), []byte(c.patch))
+		if err != nil {
+			t.Errorf("Expected %v to be applied to %v, but got %v", c.patch, c.doc, err)
+		} else if string(got) != c.want {
+			t.Errorf("Expected %v applied to %v to be %v, but got %s", c.patch, c.doc, c.want, got)
+		}
+	}
+
+	if _, err := MergePatch([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("Expected a malformed patch to be invalid, but got %v", err)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	cases := []struct{ doc, patch, want string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc"]}]`, `{"foo":["bar",["abc"]]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"a":{"b":[1]}}`, `[{"op":"copy","from":"/a/b","path":"/c"},{"op":"add","path":"/c/-","value":2}]`,
+			`{"a":{"b":[1]},"c":[1,2]}`},
+		{`{"a/b":1,"m~n":2}`, `[{"op":"test","path":"/a~1b","value":1.0},{"op":"remove","path":"/m~0n"}]`, `{"a/b":1}`},
+		{`{"foo":null}`, `[{"op":"test","path":"/foo","value":null},{"op":"replace","path":"","value":[]}]`, `[]`},
+	}
+	for _, c := range cases {
+		got, err := JSONPatch([]byte(c.doc), []byte(c.patch))
+		if err != nil {
+			t.Errorf("Expected %v to be applied to %v, but got %v", c.patch, c.doc, err)
+		} else if string(got) != c.want {
+			t.Errorf("Expected %v applied to %v to be %v, but got %s", c.patch, c.doc, c.want, got)
+		}
+	}
+
+	failures := map[string]error{
+		`{"op":"add","path":"/baz/bat","value":"qux"}`: ErrConflict,
+		`{"op":"remove","path":"/foo/2"}`:              ErrConflict,
+		`{"op":"test","path":"/foo/0","value":"baz"}`:  ErrConflict,
+		`{"op":"add","path":"/foo/01","value":"qux"}`:  ErrInvalidPatch,
+		`{"op":"add","path":"foo","value":"qux"}`:      ErrInvalidPatch,
+		`{"op":"add","path":"/baz"}`:                   ErrInvalidPatch,
+		`{"op":"move","from":"/foo","path":"/foo/0"}`:  ErrInvalidPatch,
+		`{"op":"update","path":"/foo"}`:                ErrInvalidPatch,
+	}
+	for operation, want := range failures {
+		_, err := JSONPatch([]byte(`{"foo":["bar","baz"]}`), []byte("["+operation+"]"))
+		if !errors.Is(err, want) {
+			t.Errorf("Expected %v to fail with %v, but got %v", operation, want, err)
+		}
+	}
+}
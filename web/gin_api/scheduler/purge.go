@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/jomifepe/gin_api/blob"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// trashStore is used to define the database calls used by the purger
+type trashStore interface {
+	PurgeDeletedTasks(before time.Time, limit int) ([]model.Attachment, int, error)
+	PurgeDeletedUsers(before time.Time, limit int) ([]model.Attachment, int, error)
+}
+
+// Purger permanently deletes the tasks and users that have been on the trash for longer than its retention period,
+// along with the content of their attachments
+type Purger struct {
+	Store     trashStore
+	Blobs     blob.BlobStore
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+// NewPurger initializes a Purger that checks the trash every <interval>, for tasks and users deleted more than
+// <retention> ago
+func NewPurger(store trashStore, blobs blob.BlobStore, interval time.Duration, retention time.Duration) *Purger {
+	return &Purger{
+		Store:     store,
+		Blobs:     blobs,
+		Interval:  interval,
+		Retention: retention,
+	}
+}
+
+// Start runs the purger on the background, right away and then every <p.Interval>, until <ctx> is done
+func (p *Purger) Start(ctx context.Context) {
+	logging.Logger.WithFields(logrus.Fields{
+		"interval": p.Interval,
+		"retention": p.Retention,
+	}).Infoln("[PURGE] Starting...")
+
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := p.Run(time.Now()); err != nil {
+				logging.Logger.WithFields(logrus.Fields{
+					"error": err,
+				}).Errorln("[PURGE] Failed to purge the trash")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run permanently deletes every task and user that was moved to the trash before <now> minus <p.Retention>,
+// returning how many were deleted. The content of their attachments is deleted from the blob store after their
+// rows are, so failing to delete it only leaves unreferenced blobs behind
+func (p *Purger) Run(now time.Time) (int, error) {
+	before, purged := now.Add(-p.Retention), 0
+	for _, purge := range []func(time.Time, int) ([]model.Attachment, int, error){
+		p.Store.PurgeDeletedTasks,
+		p.Store.PurgeDeletedUsers,
+	} {
+		for {
+			attachments, count, err := purge(before, batchSize)
+			if err != nil {
+				return purged, err
+			}
+			purged += count
+			p.deleteBlobs(attachments)
+			if count < batchSize {
+				break
+			}
+		}
+	}
+	return purged, nil
+}
+
+// deleteBlobs deletes the content of the specified attachments from the blob store, logging the failures
+func (p *Purger) deleteBlobs(attachments []model.Attachment) {
+	for _, a := range attachments {
+		if err := p.Blobs.Delete(a.BlobKey); err != nil {
+			logging.Logger.WithFields(logrus.Fields{
+				"attachment_id": a.ID,
+				"error": err,
+			}).Errorln("[PURGE] Failed to delete attachment content")
+		}
+	}
+}
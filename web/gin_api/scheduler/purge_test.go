@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jomifepe/gin_api/blob"
+	"github.com/jomifepe/gin_api/model"
+)
+
+// fakeTrash holds the deletion dates of the trashed tasks and users, by id
+type fakeTrash struct {
+	tasks map[int]time.Time
+	users map[int]time.Time
+}
+
+func purgeBefore(rows map[int]time.Time, before time.Time, limit int) ([]model.Attachment, int, error) {
+	var attachments []model.Attachment
+	for id, deletedAt := range rows {
+		if len(attachments) == limit {
+			break
+		}
+		if deletedAt.Before(before) {
+			delete(rows, id)
+			attachments = append(attachments, model.Attachment{BlobKey: string(rune('a' + id%26))})
+		}
+	}
+	return attachments, len(attachments), nil
+}
+
+func (f *fakeTrash) PurgeDeletedTasks(before time.Time, limit int) ([]model.Attachment, int, error) {
+	return purgeBefore(f.tasks, before, limit)
+}
+
+func (f *fakeTrash) PurgeDeletedUsers(before time.Time, limit int) ([]model.Attachment, int, error) {
+	return purgeBefore(f.users, before, limit)
+}
+
+// fakeBlobs records the deleted keys
+type fakeBlobs struct {
+	deleted []string
+}
+
+func (b *fakeBlobs) Put(string, io.Reader) error     { return nil }
+func (b *fakeBlobs) Get(string) (blob.Object, error) { return nil, blob.ErrNotFound }
+func (b *fakeBlobs) Delete(key string) error         { b.deleted = append(b.deleted, key); return nil }
+
+func TestPurgerRun(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	trash := &fakeTrash{tasks: map[int]time.Time{}, users: map[int]time.Time{1: now.Add(-31 * 24 * time.Hour)}}
+	for id := 1; id <= batchSize+1; id++ {
+		trash.tasks[id] = now.Add(-31 * 24 * time.Hour)
+	}
+	trash.tasks[batchSize+2] = now.Add(-time.Hour)
+	blobs := &fakeBlobs{}
+
+	purger := NewPurger(trash, blobs, time.Hour, 30*24*time.Hour)
+	purged, err := purger.Run(now)
+	if err != nil {
+		t.Fatalf("Expected the trash to be purged, but got %v", err)
+	}
+	if purged != batchSize+2 {
+		t.Errorf("Expected %v tasks and users to be purged, but got %v", batchSize+2, purged)
+	}
+	if len(trash.tasks) != 1 || len(trash.users) != 0 {
+		t.Errorf("Expected only the recently deleted task to be kept, but got %v tasks and %v users",
+			len(trash.tasks), len(trash.users))
+	}
+	if len(blobs.deleted) != purged {
+		t.Errorf("Expected the content of %v attachments to be deleted, but got %v", purged, len(blobs.deleted))
+	}
+}
@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RecurrenceDaily   = "daily"
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+	// cronSearchYears is how far ahead a cron expression is searched for its next occurrence
+	cronSearchYears = 5
+)
+
+var (
+	// cronDescriptors are the cron expression shorthands that are supported
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8,
+		"sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	weekdayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// Recurrence is the rule that defines when a recurring task occurs
+type Recurrence interface {
+	// Next returns the first occurrence after <t>, or the zero time if there's none
+	Next(t time.Time) time.Time
+}
+
+// ParseRecurrence parses a recurrence rule, that is either "daily", "weekly", "monthly" (repeating at the same time
+// of the day, and day of the week or month), or a cron expression with the minute, hour, day of the month, month and
+// day of the week fields (or a shorthand like "@daily"), evaluated in UTC
+func ParseRecurrence(rule string) (Recurrence, error) {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	switch rule {
+	case RecurrenceDaily:
+		return interval{days: 1}, nil
+	case RecurrenceWeekly:
+		return interval{days: 7}, nil
+	case RecurrenceMonthly:
+		return interval{months: 1}, nil
+	}
+	if expression, ok := cronDescriptors[rule]; ok {
+		rule = expression
+	}
+	return parseCron(rule)
+}
+
+// interval is a recurrence that repeats after a number of days or months
+type interval struct {
+	days   int
+	months int
+}
+
+// Next returns the instant <i.days> and <i.months> after <t>. If that month is shorter than the day of <t>, it
+// returns its last day instead
+func (i interval) Next(t time.Time) time.Time {
+	return i.nextOn(t, t.Day())
+}
+
+// nextOn returns the instant <i.days> and <i.months> after <t>, on the day of the month <day> if it repeats monthly,
+// or on the last day of the month if it's shorter. Counting from the same <day> keeps the occurrences of a series
+// from drifting to the end of the month (e.g. January 31st, February 28th, March 31st)
+func (i interval) nextOn(t time.Time, day int) time.Time {
+	if i.months == 0 {
+		return t.AddDate(0, 0, i.days)
+	}
+	first := time.Date(t.Year(), t.Month()+time.Month(i.months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+		t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1+i.days)
+}
+
+// cron is a recurrence defined by a cron expression. Each field is a bit set of the values that match it
+type cron struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDay tells if either of the day fields is a wildcard, in which case both must match. Otherwise, matching
+	// either of them is enough
+	anyDay bool
+}
+
+// cronField defines the bounds and names of the values of a cron expression field
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of the month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: monthNames},
+	{name: "day of the week", min: 0, max: 7, names: weekdayNames},
+}
+
+// parseCron parses a cron expression with 5 space-separated fields, which support wildcards (*), values, ranges
+// (1-5), steps (*/15 or 1-30/2) and lists of them (1,15,30), as well as month and weekday names (jan, mon)
+func parseCron(expression string) (Recurrence, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("recurrence %q isn't daily, weekly, monthly or a cron expression with %v fields",
+			expression, len(cronFields))
+	}
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 /* 7 is also sunday */ {
+		sets[4] |= 1
+	}
+	return cron{
+		minute:     sets[0],
+		hour:       sets[1],
+		dayOfMonth: sets[2],
+		month:      sets[3],
+		dayOfWeek:  sets[4],
+		anyDay:     strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField parses a field of a cron expression to a bit set of the values that match it
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("invalid step %q on the %v field", part[i+1:], bounds.name)
+			}
+			span, step = part[:i], s
+		}
+
+		low, high := bounds.min, bounds.max
+		if span != "*" {
+			bound := strings.SplitN(span, "-", 2)
+			var err error
+			if low, err = parseCronValue(bound[0], bounds); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bound) == 2 {
+				if high, err = parseCronValue(bound[1], bounds); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				high = bounds.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range %q on the %v field", span, bounds.name)
+			}
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// parseCronValue parses a number or name of a cron expression field, within its bounds
+func parseCronValue(value string, bounds cronField) (int, error) {
+	if v, ok := bounds.names[value]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < bounds.min || v > bounds.max {
+		return 0, fmt.Errorf("invalid value %q on the %v field, must be between %v and %v", value, bounds.name,
+			bounds.min, bounds.max)
+	}
+	return v, nil
+}
+
+// Next returns the first minute after <t> that matches the expression, in UTC, or the zero time if there's none in
+// the next cronSearchYears years (e.g. on the 30th of February)
+func (c cron) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !matches(c.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !matches(c.hour, t.Hour()):
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !matches(c.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay checks if the day of <t> matches the day of the month and day of the week fields
+func (c cron) matchesDay(t time.Time) bool {
+	dayOfMonth, dayOfWeek := matches(c.dayOfMonth, t.Day()), matches(c.dayOfWeek, int(t.Weekday()))
+	if c.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+func matches(set uint64, value int) bool {
+	return set&(1<<uint(value)) != 0
+}
@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jomifepe/gin_api/model"
+)
+
+func TestParseRecurrenceNext(t *testing.T) {
+	from := time.Date(2021, time.January, 31, 9, 30, 0, 0, time.UTC) // a sunday
+	rules := map[string]time.Time{
+		"daily":           time.Date(2021, time.February, 1, 9, 30, 0, 0, time.UTC),
+		"Weekly":          time.Date(2021, time.February, 7, 9, 30, 0, 0, time.UTC),
+		"monthly":         time.Date(2021, time.February, 28, 9, 30, 0, 0, time.UTC),
+		"*/15 * * * *":    time.Date(2021, time.January, 31, 9, 45, 0, 0, time.UTC),
+		"0 8 * * mon-fri": time.Date(2021, time.February, 1, 8, 0, 0, 0, time.UTC),
+		"0 0 1,15 * *":    time.Date(2021, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 13 * 5":     time.Date(2021, time.February, 5, 12, 0, 0, 0, time.UTC),
+		"30 9 * * 7":      time.Date(2021, time.February, 7, 9, 30, 0, 0, time.UTC),
+		"0 0 29 2 *":      time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"@yearly":         time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *":      {},
+	}
+
+	for rule, expected := range rules {
+		recurrence, err := ParseRecurrence(rule)
+		if err != nil {
+			t.Errorf("Expected recurrence %q to be valid, but got %v", rule, err)
+			continue
+		}
+		if next := recurrence.Next(from); !next.Equal(expected) {
+			t.Errorf("Expected the next occurrence of %q to be %v, but got %v", rule, expected, next)
+		}
+	}
+}
+
+func TestParseRecurrenceInvalid(t *testing.T) {
+	for _, rule := range []string{"", "hourly", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *",
+		"*/0 * * * *", "* * * foo *"} {
+		if _, err := ParseRecurrence(rule); err == nil {
+			t.Errorf("Expected recurrence %q to be invalid, but it was valid", rule)
+		}
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	now := time.Date(2021, time.March, 10, 12, 0, 0, 0, time.UTC)
+	due := time.Date(2021, time.March, 1, 8, 0, 0, 0, time.UTC)
+	task := model.Task{ID: 7, UserID: 1, Description: "Water the plants", DueAt: &due, Recurrence: "weekly",
+		Tags: []model.Tag{{ID: 3}}}
+
+	next := NextOccurrence(task, now)
+	if next == nil {
+		t.Fatalf("Expected a next occurrence, but got none")
+	}
+	if expected := time.Date(2021, time.March, 15, 8, 0, 0, 0, time.UTC); !next.DueAt.Equal(expected) {
+		t.Errorf("Expected the next occurrence to be due at %v, but got %v", expected, next.DueAt)
+	}
+	if next.RecurredFromID == nil || *next.RecurredFromID != task.ID || len(next.TagIDs) != 1 || next.Completed {
+		t.Errorf("Expected the next occurrence to copy the task, but got %+v", next)
+	}
+
+	task.Recurrence = "0 0 30 2 *"
+	if next := NextOccurrence(task, now); next != nil {
+		t.Errorf("Expected no next occurrence, but got one due at %v", next.DueAt)
+	}
+}
+
+func TestNextOccurrenceMonthly(t *testing.T) {
+	due := time.Date(2021, time.January, 31, 9, 30, 0, 0, time.UTC)
+	task := model.Task{ID: 1, UserID: 1, Description: "Pay the rent", DueAt: &due, Recurrence: "monthly"}
+
+	for _, expected := range []time.Time{
+		time.Date(2021, time.February, 28, 9, 30, 0, 0, time.UTC),
+		time.Date(2021, time.March, 31, 9, 30, 0, 0, time.UTC),
+		time.Date(2021, time.April, 30, 9, 30, 0, 0, time.UTC),
+		time.Date(2021, time.May, 31, 9, 30, 0, 0, time.UTC),
+	} {
+		next := NextOccurrence(task, *task.DueAt)
+		if next == nil {
+			t.Fatalf("Expected a next occurrence, but got none")
+		}
+		if !next.DueAt.Equal(expected) {
+			t.Fatalf("Expected the next occurrence to be due at %v, but got %v", expected, next.DueAt)
+		}
+		if next.RecurrenceDay != 31 {
+			t.Errorf("Expected the next occurrence to keep the day 31, but got %v", next.RecurrenceDay)
+		}
+		task = *next
+	}
+}
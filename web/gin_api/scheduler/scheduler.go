@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// batchSize is the maximum number of recurring tasks loaded, or trashed rows purged, at once
+const batchSize = 100
+
+// taskStore is used to define the database calls used by the scheduler
+type taskStore interface {
+	GetDueRecurringTasks(now time.Time, limit int) ([]model.Task, error)
+	RecurTask(t model.Task, next *model.Task) (bool, error)
+}
+
+// Scheduler creates the next occurrence of the recurring tasks that are completed or due
+type Scheduler struct {
+	Store    taskStore
+	Interval time.Duration
+}
+
+// NewScheduler initializes a Scheduler that checks for recurring tasks every <interval>
+func NewScheduler(store taskStore, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		Store:    store,
+		Interval: interval,
+	}
+}
+
+// Start runs the scheduler on the background, right away and then every <s.Interval>, until <ctx> is done
+func (s *Scheduler) Start(ctx context.Context) {
+	logging.Logger.WithFields(logrus.Fields{
+		"interval": s.Interval,
+	}).Infoln("[SCHED] Starting...")
+
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := s.Run(time.Now()); err != nil {
+				logging.Logger.WithFields(logrus.Fields{
+					"error": err,
+				}).Errorln("[SCHED] Failed to create task occurrences")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run creates the next occurrence of every recurring task that is completed or due at <now>, returning how many were
+// created. Each task recurs only once, even if the scheduler runs concurrently or is restarted, since it's marked as
+// recurred on the same transaction that creates its next occurrence
+func (s *Scheduler) Run(now time.Time) (int, error) {
+	created := 0
+	for {
+		tasks, err := s.Store.GetDueRecurringTasks(now, batchSize)
+		if err != nil {
+			return created, err
+		}
+		for _, t := range tasks {
+			next := NextOccurrence(t, now)
+			ok, err := s.Store.RecurTask(t, next)
+			if err != nil {
+				return created, err
+			}
+			if ok && next != nil {
+				created++
+			}
+		}
+		if len(tasks) < batchSize {
+			return created, nil
+		}
+	}
+}
+
+// NextOccurrence returns the next occurrence of a recurring task, with the same fields and tags, due on the first
+// occurrence of its recurrence after <now>. Occurrences are counted from the task due date, or from <now> if it has
+// none, so the time of the day is kept. Monthly occurrences keep the day of the month of the first one of the series
+// (see model.Task.RecurrenceDay). Returns nil if the task recurrence is invalid or has no occurrences left
+func NextOccurrence(t model.Task, now time.Time) *model.Task {
+	recurrence, err := ParseRecurrence(t.Recurrence)
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": t.ID,
+			"error": err,
+		}).Warnln("[SCHED] Task has an invalid recurrence")
+		return nil
+	}
+
+	due := now
+	if t.DueAt != nil {
+		due = *t.DueAt
+	}
+	next, day := recurrence.Next, 0
+	if i, ok := recurrence.(interval); ok && i.months > 0 {
+		if day = t.RecurrenceDay; day == 0 {
+			day = due.Day()
+		}
+		next = func(from time.Time) time.Time {
+			return i.nextOn(from, day)
+		}
+	}
+	for due = next(due); !due.IsZero() && !due.After(now); {
+		due = next(due)
+	}
+	if due.IsZero() {
+		return nil
+	}
+
+	tagIDs := make([]int, len(t.Tags))
+	for i, tag := range t.Tags {
+		tagIDs[i] = tag.ID
+	}
+	return &model.Task{
+		UserID:         t.UserID,
+		ProjectID:      t.ProjectID,
+		Description:    t.Description,
+		ParentID:       t.ParentID,
+		DueAt:          &due,
+		Priority:       t.Priority,
+		Notes:          t.Notes,
+		Recurrence:     t.Recurrence,
+		RecurrenceDay:  day,
+		RecurredFromID: &t.ID,
+		TagIDs:         tagIDs,
+	}
+}
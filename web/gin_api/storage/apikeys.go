@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// CreateAPIKey registers a new API key on the database
+func (conn *DBConn) CreateAPIKey(k model.APIKey) (model.APIKey, error) {
+	result := conn.DB.Create(&k)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": k.UserID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't create API key")
+		return model.APIKey{}, result.Error
+	}
+	if result.RowsAffected <= 0 {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": k.UserID,
+		}).Errorln("[DB] No rows were affected when creating API key")
+		return model.APIKey{}, errors.New("now rows were affected")
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": k.ID,
+		"user_id": k.UserID,
+	}).Infoln("[DB] Created new API key")
+	return k, nil
+}
+
+// GetUserAPIKeys returns all the API keys of the user with the specified id, including expired ones
+func (conn *DBConn) GetUserAPIKeys(userID int) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	result := conn.DB.Where("user_id = ?", userID).Order("created_at").Find(&keys)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get user API keys")
+		return []model.APIKey{}, result.Error
+	}
+	return keys, nil
+}
+
+// GetAPIKey returns the unexpired API key with the hash <keyHash>, or gorm.ErrRecordNotFound if there is no such key
+func (conn *DBConn) GetAPIKey(keyHash string) (model.APIKey, error) {
+	var key model.APIKey
+	result := conn.DB.Where("key_hash = ? AND expires_at > ?", keyHash, time.Now()).First(&key)
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"error": result.Error,
+			}).Errorln("[DB] Couldn't get API key")
+		}
+		return model.APIKey{}, result.Error
+	}
+	return key, nil
+}
+
+// TouchAPIKey records that the API key with the specified id was used at <usedAt>
+func (conn *DBConn) TouchAPIKey(id int, usedAt time.Time) error {
+	result := conn.DB.Model(&model.APIKey{ID: id}).Update("last_used_at", usedAt)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't update API key last usage")
+		return result.Error
+	}
+	return nil
+}
+
+// DeleteUserAPIKey revokes the API key with the specified id, if it belongs to the user with the id <userID>.
+// Returns gorm.ErrRecordNotFound if there's no such key.
+func (conn *DBConn) DeleteUserAPIKey(userID int, id int) error {
+	result := conn.DB.Delete(&model.APIKey{}, "id = ? AND user_id = ?", id, userID)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"id": id,
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete API key")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+		"user_id": userID,
+	}).Infoln("[DB] Deleted API key")
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// CreateAttachment registers a new attachment of a task, whose content was already stored
+func (conn *DBConn) CreateAttachment(attachment model.Attachment) (model.Attachment, error) {
+	if result := conn.DB.Create(&attachment); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": result.Error,
+			"attachment": attachment,
+		}).Errorln("[DB] Couldn't create attachment")
+		return model.Attachment{}, result.Error
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": attachment.ID,
+		"task_id": attachment.TaskID,
+	}).Infoln("[DB] Created new attachment")
+	return attachment, nil
+}
+
+// GetAttachments returns all the attachments of the task with the specified id, oldest first
+func (conn *DBConn) GetAttachments(taskID int) ([]model.Attachment, error) {
+	var attachments []model.Attachment
+	if result := conn.DB.Where("task_id = ?", taskID).Order("id").Find(&attachments); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get attachments")
+		return []model.Attachment{}, result.Error
+	}
+	return attachments, nil
+}
+
+// GetProjectAttachments returns all the attachments of the tasks of the project with the specified id
+func (conn *DBConn) GetProjectAttachments(projectID int) ([]model.Attachment, error) {
+	var attachments []model.Attachment
+	result := conn.DB.Where("task_id IN (SELECT id FROM tasks WHERE project_id = ?)", projectID).Find(&attachments)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"project_id": projectID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get project attachments")
+		return []model.Attachment{}, result.Error
+	}
+	return attachments, nil
+}
+
+// GetAttachment returns the attachment with the specified id, if it's on the task with the id <taskID>
+func (conn *DBConn) GetAttachment(taskID int, id int) (model.Attachment, error) {
+	var attachment model.Attachment
+	if result := conn.DB.Where("task_id = ?", taskID).First(&attachment, "id = ?", id); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"attachment_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get attachment by id")
+		return model.Attachment{}, result.Error
+	}
+	return attachment, nil
+}
+
+// DeleteAttachment deletes the attachment with the specified id, if it's on the task with the id <taskID>. Its
+// content must be deleted from the blob store separately. Returns gorm.ErrRecordNotFound if there is no such
+// attachment.
+func (conn *DBConn) DeleteAttachment(taskID int, id int) error {
+	result := conn.DB.Where("task_id = ?", taskID).Delete(&model.Attachment{}, id)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"attachment_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete attachment by id")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Deleted existing attachment")
+	return nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// AttemptStore is the Postgres implementation of auth.AttemptStore, that keeps the failed sign in attempts counters
+// on the database, so that they're shared between instances and survive restarts.
+type AttemptStore struct {
+	DBConn
+}
+
+// NewAttemptStore return an AttemptStore.
+func NewAttemptStore(conn *DBConn) *AttemptStore {
+	return &AttemptStore{
+		DBConn{DB: conn.DB, FullTextSearch: conn.FullTextSearch},
+	}
+}
+
+func (conn *DBConn) GetLoginAttempts(key string) (auth.LoginAttempts, error) {
+	attempts := auth.LoginAttempts{Key: key}
+	if result := conn.DB.Where("key = ?", key).Limit(1).Find(&attempts); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"key": key,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get login attempts")
+		return auth.LoginAttempts{}, result.Error
+	}
+	return attempts, nil
+}
+
+func (conn *DBConn) IncrementLoginAttempts(key string, now time.Time, window time.Duration) (auth.LoginAttempts, error) {
+	var attempts auth.LoginAttempts
+	result := conn.DB.Raw(`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING *`, key, now, now.Add(-window)).Scan(&attempts)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"key": key,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't increment login attempts")
+		return auth.LoginAttempts{}, result.Error
+	}
+	return attempts, nil
+}
+
+func (conn *DBConn) LockLogin(key string, until time.Time) error {
+	result := conn.DB.Model(&auth.LoginAttempts{}).Where("key = ?", key).Update("locked_until", until)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"key": key,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't lock login")
+		return result.Error
+	}
+	return nil
+}
+
+func (conn *DBConn) ResetLoginAttempts(key string) error {
+	if result := conn.DB.Delete(auth.LoginAttempts{}, "key = ?", key); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"key": key,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't reset login attempts")
+		return result.Error
+	}
+	return nil
+}
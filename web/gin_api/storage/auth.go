@@ -5,6 +5,8 @@ import (
 	"github.com/jomifepe/gin_api/api/auth"
 	"github.com/jomifepe/gin_api/logging"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
 )
 
 type AuthStore struct {
@@ -14,23 +16,24 @@ type AuthStore struct {
 // NewAuthStore return an AuthStore.
 func NewAuthStore(conn *DBConn) *AuthStore {
 	return &AuthStore{
-		DBConn{DB: conn.DB},
+		DBConn{DB: conn.DB, FullTextSearch: conn.FullTextSearch},
 	}
 }
 
 // RegisterAccess registers a new access (auth.AccessDetails) on the database.
-// Deletes existing ones with the same user id before creating.
+// Other sessions of the same user are kept, but their expired entries are deleted.
 func (conn *DBConn) RegisterAccess(t auth.AccessDetails) error {
-	// Delete other existing access entries
+	// Delete expired access entries
 	go func () /* no concurrency, can be async */ {
-		if dr := conn.DB.Delete(&t, "user_id = ? AND access_uuid != ?", t.UserID, t.AccessUUID); dr.Error != nil {
+		dr := conn.DB.Delete(auth.AccessDetails{}, "user_id = ? AND refresh_expires_at < ?", t.UserID, time.Now())
+		if dr.Error != nil {
 			logging.Logger.WithFields(logrus.Fields{
 				"user_id": t.UserID,
-			}).Warnln("[DB] Couldn't delete existing access before creating new one")
+			}).Warnln("[DB] Couldn't delete expired access before creating new one")
 		} else if dr.RowsAffected > 0 {
 			logging.Logger.WithFields(logrus.Fields{
 				"user_id": t.UserID,
-			}).Warnf("[DB] Deleted %v old access entries", dr.RowsAffected)
+			}).Warnf("[DB] Deleted %v expired access entries", dr.RowsAffected)
 		}
 	}()
 
@@ -80,4 +83,118 @@ func (conn *DBConn) DeleteAccess(t auth.AccessDetails) error {
 		"access_uuid": t.AccessUUID,
 	}).Infoln("[DB] Deleted user access")
 	return nil
-}
\ No newline at end of file
+}
+
+// RotateAccess replaces the access entry that holds the refresh token <refreshUUID> with a new one, on the same
+// transaction. The session's device and sign in time are carried over to the new entry.
+// If no entry holds that refresh token, it was already rotated or revoked and auth.ErrTokenReused is returned.
+func (conn *DBConn) RotateAccess(refreshUUID string, next auth.AccessDetails) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		var current auth.AccessDetails
+		if fr := tx.Where("refresh_uuid = ?", refreshUUID).First(&current); fr.Error != nil {
+			if errors.Is(fr.Error, gorm.ErrRecordNotFound) {
+				return auth.ErrTokenReused
+			}
+			return fr.Error
+		}
+		next.Device = current.Device
+		next.CreatedAt = current.CreatedAt
+
+		dr := tx.Delete(auth.AccessDetails{}, "refresh_uuid = ?", refreshUUID)
+		if dr.Error != nil {
+			return dr.Error
+		}
+		if dr.RowsAffected == 0 {
+			return auth.ErrTokenReused
+		}
+		return tx.Create(&next).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": next.UserID,
+			"family_uuid": next.FamilyUUID,
+			"error": err,
+		}).Errorln("[DB] Couldn't rotate user access")
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": next.UserID,
+		"access_uuid": next.AccessUUID,
+		"family_uuid": next.FamilyUUID,
+	}).Infoln("[DB] Rotated user access")
+	return nil
+}
+
+// DeleteAccessFamily deletes every access entry that belongs to the token family <familyUUID>
+func (conn *DBConn) DeleteAccessFamily(familyUUID string) error {
+	result := conn.DB.Delete(auth.AccessDetails{}, "family_uuid = ?", familyUUID)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"family_uuid": familyUUID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete access family")
+		return result.Error
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"family_uuid": familyUUID,
+	}).Infof("[DB] Deleted %v access entries from family", result.RowsAffected)
+	return nil
+}
+
+// GetUserAccesses returns every access entry of the user with the specified id, one for each of its sessions
+func (conn *DBConn) GetUserAccesses(userID int) ([]auth.AccessDetails, error) {
+	var accesses []auth.AccessDetails
+	result := conn.DB.Where("user_id = ? AND refresh_expires_at >= ?", userID, time.Now()).
+		Order("updated_at DESC").Find(&accesses)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get user accesses")
+		return []auth.AccessDetails{}, result.Error
+	}
+	return accesses, nil
+}
+
+// DeleteUserAccessFamily deletes the access entries of the token family <familyUUID>, if it belongs to the user with
+// the specified id. Returns gorm.ErrRecordNotFound if the user has no such family.
+func (conn *DBConn) DeleteUserAccessFamily(userID int, familyUUID string) error {
+	result := conn.DB.Delete(auth.AccessDetails{}, "user_id = ? AND family_uuid = ?", userID, familyUUID)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"family_uuid": familyUUID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete user access family")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"family_uuid": familyUUID,
+	}).Infoln("[DB] Deleted user access family")
+	return nil
+}
+
+// DeleteUserAccesses deletes every access entry of the user with the specified id, signing it out of all sessions
+// except the ones with the token families in <keepFamilyUUIDs>
+func (conn *DBConn) DeleteUserAccesses(userID int, keepFamilyUUIDs ...string) error {
+	query := conn.DB.Where("user_id = ?", userID)
+	if len(keepFamilyUUIDs) > 0 {
+		query = query.Where("family_uuid NOT IN ?", keepFamilyUUIDs)
+	}
+	result := query.Delete(auth.AccessDetails{})
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete user accesses")
+		return result.Error
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": userID,
+	}).Infof("[DB] Deleted %v user access entries", result.RowsAffected)
+	return nil
+}
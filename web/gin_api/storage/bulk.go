@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// insertBatchSize is the maximum number of rows inserted with a single statement
+const insertBatchSize = 100
+
+// taskTagLink is a row of the join table between tasks and their tags
+type taskTagLink struct {
+	TaskID int
+	TagID  int
+}
+
+func (taskTagLink) TableName() string {
+	return "task_tags"
+}
+
+// taskBulk applies the operations of a bulk request of the user with the id <userID>, all on the transaction <db> if
+// <atomic>, or otherwise each one on its own transaction, recording the error of each operation on <errs>
+type taskBulk struct {
+	db     *gorm.DB
+	atomic bool
+	userID int
+	ops    []model.TaskOperation
+	errs   []error
+}
+
+// ApplyTaskOperations applies the operations of a bulk request of the user with the id <userID>, returning the error of
+// each one, nil if it succeeded. The tasks of the create operations are inserted first, in batches, and the ids of the
+// new tasks are set on them. Then the update, toggle and delete operations are applied in order, like UpdateTask,
+// ToggleTask and DeleteTask. Each operation is recorded on the task activity log on its transaction. If <atomic>, every
+// operation is applied on the same transaction, which is rolled back as soon as one of them fails, failing the others
+// with model.ErrBulkAborted. Otherwise, each one is applied independently, and the tasks of a batch that fails are
+// inserted again one by one, so that only the ones that can't be created fail
+func (conn *DBConn) ApplyTaskOperations(userID int, ops []model.TaskOperation, atomic bool) []error {
+	b := &taskBulk{db: conn.DB, atomic: atomic, userID: userID, ops: ops, errs: make([]error, len(ops))}
+	var err error
+	if atomic {
+		err = conn.DB.Transaction(func(tx *gorm.DB) error {
+			b.db = tx
+			return b.apply()
+		})
+	} else {
+		err = b.apply()
+	}
+
+	failed := 0
+	for i := range b.errs {
+		if err != nil && b.errs[i] == nil {
+			b.errs[i] = model.ErrBulkAborted
+		}
+		if b.errs[i] != nil {
+			failed++
+		}
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"operations": len(ops),
+		"failed": failed,
+		"atomic": atomic,
+	}).Infoln("[DB] Applied bulk task operations")
+	return b.errs
+}
+
+// apply applies the operations of the bulk, returning the error of the first one that failed if it's atomic
+func (b *taskBulk) apply() error {
+	var (
+		tasks   []*model.Task
+		indexes []int
+	)
+	for i, op := range b.ops {
+		if op.Op != model.TaskActionCreate {
+			continue
+		}
+		t := op.Task
+		t.ID, t.UserID, t.Version, t.DeletedAt = 0, b.userID, 1, gorm.DeletedAt{}
+		if err := checkTaskCreatable(b.db, *t); err != nil {
+			if b.fail(i, err) {
+				return err
+			}
+			continue
+		}
+		tasks, indexes = append(tasks, t), append(indexes, i)
+	}
+	for start := 0; start < len(tasks); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		err := b.run(func(tx *gorm.DB) error {
+			return createTasks(tx, b.userID, tasks[start:end])
+		})
+		if err == nil {
+			continue
+		}
+		if b.atomic {
+			for _, i := range indexes[start:end] {
+				b.fail(i, err)
+			}
+			return err
+		}
+		// the batch was rolled back, so its tasks are inserted one by one, to only fail the ones that can't be
+		for k := start; k < end; k++ {
+			tasks[k].ID = 0
+			err := b.run(func(tx *gorm.DB) error {
+				return createTasks(tx, b.userID, tasks[k:k+1])
+			})
+			if err != nil {
+				b.fail(indexes[k], err)
+			}
+		}
+	}
+
+	for i, op := range b.ops {
+		var err error
+		switch op.Op {
+		case model.TaskActionUpdate:
+			t := *op.Task
+			t.ID, t.UserID, t.Version = op.ID, b.userID, op.Version
+			err = b.change(op, func(tx *gorm.DB) error {
+				return updateTask(tx, &t, nil)
+			})
+		case model.TaskActionToggle:
+			err = b.change(op, func(tx *gorm.DB) error {
+				return toggleTask(tx, op.ID, b.userID, op.Version)
+			})
+		case model.TaskActionDelete:
+			err = b.change(op, func(tx *gorm.DB) error {
+				return deleteTask(tx, op.ID, b.userID, op.Version)
+			})
+		}
+		if err != nil && b.fail(i, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs <fn> on the transaction of the bulk, if it's atomic, or on a new transaction otherwise
+func (b *taskBulk) run(fn func(tx *gorm.DB) error) error {
+	if b.atomic {
+		return fn(b.db)
+	}
+	return b.db.Transaction(fn)
+}
+
+// change runs <change> on the task of the operation <op> like changeTaskOn, recording it on the task activity log,
+// on the transaction of the bulk, if it's atomic, or on a new transaction otherwise
+func (b *taskBulk) change(op model.TaskOperation, change func(tx *gorm.DB) error) error {
+	return b.run(func(tx *gorm.DB) error {
+		return changeTaskOn(tx, op.ID, b.userID, op.Version, op.Op, change)
+	})
+}
+
+// fail records the error of the operation with the index <i>, logging it if it isn't caused by the operation itself,
+// and returns true if the bulk must stop
+func (b *taskBulk) fail(i int, err error) bool {
+	if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrVersionConflict) && !isTaskRuleError(err) {
+		logging.Logger.WithFields(logrus.Fields{
+			"index": i,
+			"op": b.ops[i].Op,
+			"error": err,
+		}).Errorln("[DB] Couldn't apply bulk task operation")
+	}
+	b.errs[i] = err
+	return b.atomic
+}
+
+// checkTaskCreatable checks the parent and blockers of a new task, like CreateTask does. Returns
+// model.ErrTaskLinkNotFound if they aren't tasks of the same user or project, or model.ErrTaskBlocked if it's
+// completed while any of its blockers aren't
+func checkTaskCreatable(tx *gorm.DB, t model.Task) error {
+	if err := checkTaskLinks(tx, t); err != nil {
+		return err
+	}
+	if !t.Completed || len(t.BlockedBy) == 0 {
+		return nil
+	}
+	var count int64
+	result := tx.Model(&model.Task{}).Where("id IN ? AND completed = ?", uniqueInts(t.BlockedBy), false).Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return model.ErrTaskBlocked
+	}
+	return nil
+}
+
+// createTasks inserts new tasks of the user with the id <userID> with a single statement, along with their tags
+// (<t.TagIDs>) and blockers (<t.BlockedBy>), which must be checked with checkTaskCreatable beforehand, and records
+// them on their activity logs as created by that user. Sets the ids and tags of the new tasks on them
+func createTasks(tx *gorm.DB, userID int, tasks []*model.Task) error {
+	var tagIDs []int
+	for _, t := range tasks {
+		t.Tags = nil
+		t.TagIDs, t.BlockedBy = uniqueInts(t.TagIDs), uniqueInts(t.BlockedBy)
+		tagIDs = append(tagIDs, t.TagIDs...)
+	}
+	if result := tx.Create(&tasks); result.Error != nil {
+		return result.Error
+	}
+
+	tags := map[int]model.Tag{}
+	if len(tagIDs) > 0 {
+		var found []model.Tag
+		if result := tx.Where("id IN ? AND user_id = ?", uniqueInts(tagIDs), userID).Find(&found); result.Error != nil {
+			return result.Error
+		}
+		for _, tag := range found {
+			tags[tag.ID] = tag
+		}
+	}
+	var (
+		links        []taskTagLink
+		dependencies []model.TaskDependency
+	)
+	for _, t := range tasks {
+		t.Tags = []model.Tag{}
+		for _, id := range t.TagIDs {
+			if tag, ok := tags[id]; ok {
+				t.Tags, links = append(t.Tags, tag), append(links, taskTagLink{TaskID: t.ID, TagID: id})
+			}
+		}
+		for _, id := range t.BlockedBy {
+			dependencies = append(dependencies, model.TaskDependency{TaskID: t.ID, BlockedByID: id})
+		}
+	}
+	if len(links) > 0 {
+		if result := tx.Create(&links); result.Error != nil {
+			return result.Error
+		}
+	}
+	if len(dependencies) > 0 {
+		if result := tx.Create(&dependencies); result.Error != nil {
+			return result.Error
+		}
+	}
+	activities := make([]model.TaskActivity, len(tasks))
+	for i, t := range tasks {
+		activities[i] = model.TaskActivity{
+			TaskID:  t.ID,
+			ActorID: userID,
+			Action:  model.TaskActionCreate,
+			Changes: model.DiffTasks(model.Task{}, *t),
+		}
+	}
+	return tx.Create(&activities).Error
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// CreateTaskComment registers a new comment on a task
+func (conn *DBConn) CreateTaskComment(comment model.TaskComment) (model.TaskComment, error) {
+	if result := conn.DB.Create(&comment); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": result.Error,
+			"comment": comment,
+		}).Errorln("[DB] Couldn't create task comment")
+		return model.TaskComment{}, result.Error
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": comment.ID,
+		"task_id": comment.TaskID,
+	}).Infoln("[DB] Created new task comment")
+	return comment, nil
+}
+
+// GetTaskComments returns a page of the comments of the task with the specified id, along with the total number of
+// comments
+func (conn *DBConn) GetTaskComments(taskID int, page model.Page) ([]model.TaskComment, int64, error) {
+	var (
+		comments []model.TaskComment
+		total    int64
+	)
+	result := conn.DB.Model(&model.TaskComment{}).Where("task_id = ?", taskID).Count(&total)
+	if result.Error == nil {
+		result = conn.DB.Where("task_id = ?", taskID).Scopes(paginate(page)).Find(&comments)
+	}
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get task comments")
+		return []model.TaskComment{}, 0, result.Error
+	}
+	return comments, total, nil
+}
+
+// GetTaskComment returns the comment with the specified id, if it's on the task with the id <taskID>
+func (conn *DBConn) GetTaskComment(taskID int, id int) (model.TaskComment, error) {
+	var comment model.TaskComment
+	if result := conn.DB.Where("task_id = ?", taskID).First(&comment, "id = ?", id); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"comment_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get task comment by id")
+		return model.TaskComment{}, result.Error
+	}
+	return comment, nil
+}
+
+// UpdateTaskComment updates the body of an existing comment, if it's on the task with the id <comment.TaskID> and
+// was written by the user with the id <comment.UserID>. Returns gorm.ErrRecordNotFound if there is no such comment.
+func (conn *DBConn) UpdateTaskComment(comment model.TaskComment) (model.TaskComment, error) {
+	result := conn.DB.Model(&comment).Where("task_id = ? AND user_id = ?", comment.TaskID, comment.UserID).
+		Select("body", "updated_at").Updates(comment)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"comment": comment,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't update task comment")
+		return model.TaskComment{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return model.TaskComment{}, gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": comment.ID,
+	}).Infoln("[DB] Updated existing task comment")
+	return conn.GetTaskComment(comment.TaskID, comment.ID)
+}
+
+// DeleteTaskComment deletes the comment with the specified id, if it's on the task with the id <taskID>.
+// Returns gorm.ErrRecordNotFound if there is no such comment.
+func (conn *DBConn) DeleteTaskComment(taskID int, id int) error {
+	result := conn.DB.Where("task_id = ?", taskID).Delete(&model.TaskComment{}, id)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"comment_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete task comment by id")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Deleted existing task comment")
+	return nil
+}
+
+// recordTaskActivity appends an action performed by the user with the id <actorID> to the activity log of a task on
+// the transaction <tx>, with the fields that changed between its <before> and <after> versions
+func recordTaskActivity(tx *gorm.DB, actorID int, action string, before model.Task, after model.Task) error {
+	taskID := after.ID
+	if taskID == 0 {
+		taskID = before.ID
+	}
+	return tx.Create(&model.TaskActivity{
+		TaskID:  taskID,
+		ActorID: actorID,
+		Action:  action,
+		Changes: model.DiffTasks(before, after),
+	}).Error
+}
+
+// GetTaskHistory returns a page of the activity log of the task with the specified id, along with the total number of
+// entries
+func (conn *DBConn) GetTaskHistory(taskID int, page model.Page) ([]model.TaskActivity, int64, error) {
+	var (
+		activities []model.TaskActivity
+		total      int64
+	)
+	result := conn.DB.Model(&model.TaskActivity{}).Where("task_id = ?", taskID).Count(&total)
+	if result.Error == nil {
+		result = conn.DB.Where("task_id = ?", taskID).Scopes(paginate(page)).Find(&activities)
+	}
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": taskID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get task history")
+		return []model.TaskActivity{}, 0, result.Error
+	}
+	return activities, total, nil
+}
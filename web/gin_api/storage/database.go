@@ -1,15 +1,20 @@
 package storage
 
 import (
+	"fmt"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jomifepe/gin_api/api/auth"
+	"github.com/jomifepe/gin_api/api/middleware"
 	"github.com/jomifepe/gin_api/logging"
 	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 type DBConn struct {
 	DB *gorm.DB
+	// FullTextSearch tells if the database supports the full-text search of tasks, set up by MigrateDatabase
+	FullTextSearch bool
 }
 
 // MigrateDatabase auto migrates the database with existing model structs
@@ -19,8 +24,49 @@ func (conn *DBConn) MigrateDatabase() {
 	if err := conn.DB.AutoMigrate(
 		&model.User{},
 		&model.Task{},
+		&model.Tag{},
+		&model.TaskDependency{},
+		&model.TaskComment{},
+		&model.TaskActivity{},
+		&model.Attachment{},
+		&model.Project{},
+		&model.ProjectMember{},
+		&model.ProjectInvitation{},
 		&auth.AccessDetails{},
+		&model.PasswordReset{},
+		&auth.LoginAttempts{},
+		&model.RecoveryCode{},
+		&model.APIKey{},
+		&middleware.IdempotentResponse{},
 	); err != nil {
 		logging.Logger.Panicln("[DB] Failed to migrate database", err)
 	}
+	conn.migrateTaskSearch()
+}
+
+// migrateTaskSearch sets up the full-text search of tasks, with a generated tsvector column of the task descriptions
+// and a GIN index on it. Generated columns require Postgres 12 or newer, so if it fails, task searches fall back to
+// ILIKE matching
+func (conn *DBConn) migrateTaskSearch() {
+	conn.FullTextSearch = false
+	if conn.DB.Dialector.Name() != "postgres" {
+		logging.Logger.Warnln("[DB] Full-text search isn't supported, task searches will use ILIKE matching")
+		return
+	}
+
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf("ALTER TABLE tasks ADD COLUMN IF NOT EXISTS search_vector tsvector "+
+			"GENERATED ALWAYS AS (to_tsvector('%v', coalesce(description, ''))) STORED", taskSearchConfig)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_search_vector ON tasks USING GIN (search_vector)").Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Warnln("[DB] Couldn't set up full-text search, task searches will use ILIKE matching")
+		return
+	}
+	conn.FullTextSearch = true
 }
\ No newline at end of file
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// ancestorsQuery counts how many times a task (second argument) appears among another task (first argument) and
+	// its ancestors
+	ancestorsQuery = "WITH RECURSIVE ancestors(id, parent_id) AS (" +
+		"SELECT id, parent_id FROM tasks WHERE id = ? " +
+		"UNION SELECT tasks.id, tasks.parent_id FROM tasks JOIN ancestors ON tasks.id = ancestors.parent_id" +
+		") SELECT count(*) FROM ancestors WHERE id = ?"
+	// blockersQuery counts how many times a task (second argument) appears among the direct and indirect blockers of
+	// other tasks (first argument)
+	blockersQuery = "WITH RECURSIVE blockers(id) AS (" +
+		"SELECT blocked_by_id FROM task_dependencies WHERE task_id IN ? " +
+		"UNION SELECT task_dependencies.blocked_by_id FROM task_dependencies " +
+		"JOIN blockers ON task_dependencies.task_id = blockers.id" +
+		") SELECT count(*) FROM blockers WHERE id = ?"
+	// subtreeQuery selects the ids of a task and all its descendants
+	subtreeQuery = "WITH RECURSIVE subtree(id) AS (" +
+		"SELECT id FROM tasks WHERE id = ? " +
+		"UNION SELECT tasks.id FROM tasks JOIN subtree ON tasks.parent_id = subtree.id" +
+		") SELECT id FROM subtree"
+)
+
+// checkTaskLinks checks if the parent (<t.ParentID>) and blockers (<t.BlockedBy>) of a task are tasks of the same user
+// or project, that don't make it its own ancestor or blocker. Returns model.ErrTaskLinkNotFound or model.ErrTaskCycle
+// if they aren't. New tasks (without an id) can't be part of a cycle
+func checkTaskLinks(tx *gorm.DB, t model.Task) error {
+	if t.ParentID != nil {
+		if *t.ParentID == t.ID {
+			return model.ErrTaskCycle
+		}
+		if err := checkTasksLinkable(tx, t, []int{*t.ParentID}); err != nil {
+			return err
+		}
+		if t.ID != 0 {
+			var count int64
+			if err := tx.Raw(ancestorsQuery, *t.ParentID, t.ID).Row().Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				return model.ErrTaskCycle
+			}
+		}
+	}
+
+	if len(t.BlockedBy) == 0 {
+		return nil
+	}
+	blockers := uniqueInts(t.BlockedBy)
+	for _, id := range blockers {
+		if id == t.ID {
+			return model.ErrTaskCycle
+		}
+	}
+	if err := checkTasksLinkable(tx, t, blockers); err != nil {
+		return err
+	}
+	if t.ID != 0 {
+		var count int64
+		if err := tx.Raw(blockersQuery, blockers, t.ID).Row().Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return model.ErrTaskCycle
+		}
+	}
+	return nil
+}
+
+// checkTasksLinkable checks if all the tasks with the specified ids can be linked to <t>, being on the same project
+// (or personal tasks of the same user). Returns model.ErrTaskLinkNotFound if they can't
+func checkTasksLinkable(tx *gorm.DB, t model.Task, ids []int) error {
+	projectID := 0
+	if t.ProjectID != nil {
+		projectID = *t.ProjectID
+	}
+	var count int64
+	result := tx.Model(&model.Task{}).Scopes(tasksVisibleTo(t.UserID), tasksInProject(&projectID)).
+		Where("tasks.id IN ?", ids).Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if count != int64(len(ids)) {
+		return model.ErrTaskLinkNotFound
+	}
+	return nil
+}
+
+// replaceTaskBlockers replaces the blockers of a task with the tasks with the ids <t.BlockedBy>, unless they're nil.
+// They must be checked with checkTaskLinks beforehand
+func replaceTaskBlockers(tx *gorm.DB, t *model.Task) error {
+	if t.BlockedBy == nil {
+		return nil
+	}
+	if result := tx.Delete(model.TaskDependency{}, "task_id = ?", t.ID); result.Error != nil {
+		return result.Error
+	}
+	t.BlockedBy = uniqueInts(t.BlockedBy)
+	if len(t.BlockedBy) == 0 {
+		return nil
+	}
+	dependencies := make([]model.TaskDependency, len(t.BlockedBy))
+	for i, id := range t.BlockedBy {
+		dependencies[i] = model.TaskDependency{TaskID: t.ID, BlockedByID: id}
+	}
+	return tx.Create(&dependencies).Error
+}
+
+// checkTaskUnblocked checks if all the blockers of the task with the specified id, that aren't on the trash, are
+// completed. Returns model.ErrTaskBlocked if they aren't
+func checkTaskUnblocked(tx *gorm.DB, id int) error {
+	var count int64
+	result := tx.Model(&model.TaskDependency{}).
+		Joins("JOIN tasks ON tasks.id = task_dependencies.blocked_by_id").
+		Where("task_dependencies.task_id = ? AND tasks.completed = ? AND tasks.deleted_at IS NULL", id, false).
+		Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return model.ErrTaskBlocked
+	}
+	return nil
+}
+
+// attachTaskBlockers loads the ids of the blockers of the specified tasks, leaving out the ones on the trash
+func (conn *DBConn) attachTaskBlockers(tasks []*model.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	var (
+		ids          = make([]int, len(tasks))
+		byID         = make(map[int]*model.Task, len(tasks))
+		dependencies []model.TaskDependency
+	)
+	for i, t := range tasks {
+		ids[i], byID[t.ID] = t.ID, t
+		t.BlockedBy = []int{}
+	}
+	result := conn.DB.Joins("JOIN tasks ON tasks.id = task_dependencies.blocked_by_id").
+		Where("task_dependencies.task_id IN ? AND tasks.deleted_at IS NULL", ids).
+		Order("task_dependencies.blocked_by_id").Find(&dependencies)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, d := range dependencies {
+		byID[d.TaskID].BlockedBy = append(byID[d.TaskID].BlockedBy, d.BlockedByID)
+	}
+	return nil
+}
+
+// GetTaskTree returns the task with the specified id, if the user with the id <userID> can read it, along with all
+// its subtasks, recursively
+func (conn *DBConn) GetTaskTree(id int, userID int) (model.TaskTree, error) {
+	var tasks []model.Task
+	result := conn.DB.Scopes(tasksVisibleTo(userID)).Where("tasks.id IN ("+subtreeQuery+")", id).
+		Preload("Tags").Order("tasks.id").Find(&tasks)
+	if result.Error == nil && len(tasks) == 0 {
+		result.Error = gorm.ErrRecordNotFound
+	}
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": id,
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get task tree")
+		return model.TaskTree{}, result.Error
+	}
+
+	nodes := make(map[int]*model.TaskTree, len(tasks))
+	pointers := make([]*model.Task, len(tasks))
+	for i := range tasks {
+		nodes[tasks[i].ID] = &model.TaskTree{Task: tasks[i], Subtasks: []*model.TaskTree{}}
+		pointers[i] = &nodes[tasks[i].ID].Task
+	}
+	if err := conn.attachTaskBlockers(pointers); err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": id,
+			"error": err,
+		}).Errorln("[DB] Couldn't get blockers of task tree")
+		return model.TaskTree{}, err
+	}
+	for _, t := range tasks {
+		if t.ID != id && t.ParentID != nil {
+			parent := nodes[*t.ParentID]
+			parent.Subtasks = append(parent.Subtasks, nodes[t.ID])
+		}
+	}
+	return *nodes[id], nil
+}
+
+// isTaskRuleError checks if an error is caused by an invalid parent or blocker of a task, rather than by the database
+func isTaskRuleError(err error) bool {
+	return errors.Is(err, model.ErrTaskLinkNotFound) || errors.Is(err, model.ErrTaskCycle) ||
+		errors.Is(err, model.ErrTaskBlocked)
+}
+
+func uniqueInts(values []int) []int {
+	var (
+		unique = make([]int, 0, len(values))
+		seen   = make(map[int]bool, len(values))
+	)
+	for _, v := range values {
+		if !seen[v] {
+			unique, seen[v] = append(unique, v), true
+		}
+	}
+	return unique
+}
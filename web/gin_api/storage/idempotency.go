@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/api/middleware"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// IdempotencyStore is the Postgres implementation of middleware.IdempotencyStore, that keeps the responses to the
+// requests with an Idempotency-Key on the database, so that they're shared between instances and survive restarts.
+type IdempotencyStore struct {
+	DBConn
+}
+
+// NewIdempotencyStore return an IdempotencyStore.
+func NewIdempotencyStore(conn *DBConn) *IdempotencyStore {
+	return &IdempotencyStore{
+		DBConn{DB: conn.DB, FullTextSearch: conn.FullTextSearch},
+	}
+}
+
+// ReserveIdempotencyKey also deletes the expired responses and the expired locks of the same user, so they don't
+// pile up
+func (conn *DBConn) ReserveIdempotencyKey(response middleware.IdempotentResponse, now time.Time) (middleware.IdempotentResponse, bool, error) {
+	reserved := false
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND (expires_at <= ? OR (status = 0 AND locked_until <= ?))", response.UserID, now, now).
+			Delete(&middleware.IdempotentResponse{})
+		if result.Error != nil {
+			return result.Error
+		}
+		response.Status = 0
+		if result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&response); result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			reserved = true
+			return nil
+		}
+		return tx.Where("user_id = ? AND key = ?", response.UserID, response.Key).First(&response).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": response.UserID,
+			"error": err,
+		}).Errorln("[DB] Couldn't reserve idempotency key")
+		return middleware.IdempotentResponse{}, false, err
+	}
+	return response, reserved, nil
+}
+
+func (conn *DBConn) SaveIdempotentResponse(response middleware.IdempotentResponse) error {
+	result := conn.DB.Model(&middleware.IdempotentResponse{}).
+		Where("user_id = ? AND key = ?", response.UserID, response.Key).
+		Updates(map[string]interface{}{
+			"status": response.Status,
+			"content_type": response.ContentType,
+			"e_tag": response.ETag,
+			"body": response.Body,
+		})
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": response.UserID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't save idempotent response")
+		return result.Error
+	}
+	return nil
+}
+
+func (conn *DBConn) ReleaseIdempotencyKey(userID int, key string) error {
+	result := conn.DB.Delete(&middleware.IdempotentResponse{}, "user_id = ? AND key = ? AND status = 0", userID, key)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't release idempotency key")
+		return result.Error
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// CreatePasswordReset registers a new password reset on the database.
+// Unused resets previously issued to the same user are deleted, so only the latest one can be used.
+func (conn *DBConn) CreatePasswordReset(r model.PasswordReset) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		if dr := tx.Delete(model.PasswordReset{}, "user_id = ? AND used_at IS NULL", r.UserID); dr.Error != nil {
+			return dr.Error
+		}
+		return tx.Create(&r).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": r.UserID,
+			"error": err,
+		}).Errorln("[DB] Couldn't create password reset")
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": r.UserID,
+	}).Infoln("[DB] Created password reset")
+	return nil
+}
+
+// GetPasswordReset returns the unused and unexpired password reset with the hash <tokenHash>, or
+// gorm.ErrRecordNotFound if there is no such reset.
+func (conn *DBConn) GetPasswordReset(tokenHash string) (model.PasswordReset, error) {
+	var reset model.PasswordReset
+	result := conn.DB.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&reset)
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"error": result.Error,
+			}).Errorln("[DB] Couldn't get password reset")
+		}
+		return model.PasswordReset{}, result.Error
+	}
+	return reset, nil
+}
+
+// UsePasswordReset marks the unused and unexpired password reset with the hash <tokenHash> as used, and sets the
+// password of the user it was issued to, on the same transaction. Returns the id of that user, or
+// gorm.ErrRecordNotFound if there is no such reset.
+func (conn *DBConn) UsePasswordReset(tokenHash string, passwordHash string) (int, error) {
+	var reset model.PasswordReset
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		fr := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&reset)
+		if fr.Error != nil {
+			return fr.Error
+		}
+		if ur := tx.Model(&reset).Update("used_at", time.Now()); ur.Error != nil {
+			return ur.Error
+		}
+		return tx.Model(&model.User{ID: reset.UserID}).Update("password", passwordHash).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("[DB] Couldn't use password reset")
+		}
+		return 0, err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": reset.UserID,
+	}).Infoln("[DB] Reset user password")
+	return reset.UserID, nil
+}
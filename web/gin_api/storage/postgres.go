@@ -37,7 +37,7 @@ func ConfigurePostgresDB() *DBConn {
 		}).Panicln("[DB] Failed to connect")
 	}
 
-	dbConn := &DBConn{gormDB}
+	dbConn := &DBConn{DB: gormDB}
 	dbConn.MigrateDatabase()
 
 	logging.Logger.Infoln("[DB] Successfully connected")
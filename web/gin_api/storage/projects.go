@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+type ProjectStore struct {
+	DBConn
+}
+
+// NewProjectStore return a ProjectStore.
+func NewProjectStore(conn *DBConn) *ProjectStore {
+	return &ProjectStore{
+		DBConn{DB: conn.DB, FullTextSearch: conn.FullTextSearch},
+	}
+}
+
+// memberProject is a project along with the role of one of its members
+type memberProject struct {
+	model.Project
+	MemberRole string
+}
+
+// CreateProject registers a new project on the database, with the user with the id <ownerID> as its owner, on the
+// same transaction
+func (conn *DBConn) CreateProject(p model.Project, ownerID int) (model.Project, error) {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(&p); result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&model.ProjectMember{ProjectID: p.ID, UserID: ownerID, Role: model.ProjectRoleOwner}).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": ownerID,
+			"error": err,
+		}).Errorln("[DB] Couldn't create project")
+		return model.Project{}, err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": p.ID,
+	}).Infoln("[DB] Created new project")
+	p.Role = model.ProjectRoleOwner
+	return p, nil
+}
+
+// GetUserProjects returns all the projects the user with the specified id is a member of, with its role, sorted by
+// name
+func (conn *DBConn) GetUserProjects(userID int) ([]model.Project, error) {
+	var rows []memberProject
+	result := conn.DB.Table("projects").Select("projects.*, project_members.role AS member_role").
+		Joins("JOIN project_members ON project_members.project_id = projects.id").
+		Where("project_members.user_id = ?", userID).Order("projects.name, projects.id").Scan(&rows)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get user projects")
+		return []model.Project{}, result.Error
+	}
+	projects := make([]model.Project, len(rows))
+	for i, row := range rows {
+		projects[i] = row.Project
+		projects[i].Role = row.MemberRole
+	}
+	return projects, nil
+}
+
+// GetProject returns the project with the specified id, with the role of the user with the id <userID>, if it's a
+// member of it. Returns gorm.ErrRecordNotFound otherwise
+func (conn *DBConn) GetProject(id int, userID int) (model.Project, error) {
+	var rows []memberProject
+	result := conn.DB.Table("projects").Select("projects.*, project_members.role AS member_role").
+		Joins("JOIN project_members ON project_members.project_id = projects.id").
+		Where("projects.id = ? AND project_members.user_id = ?", id, userID).Limit(1).Scan(&rows)
+	if result.Error == nil && len(rows) == 0 {
+		result.Error = gorm.ErrRecordNotFound
+	}
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"project_id": id,
+				"user_id": userID,
+				"error": result.Error,
+			}).Errorln("[DB] Couldn't get project by id")
+		}
+		return model.Project{}, result.Error
+	}
+	project := rows[0].Project
+	project.Role = rows[0].MemberRole
+	return project, nil
+}
+
+// GetProjectRole returns the role of the user with the id <userID> on the project with the specified id, or
+// gorm.ErrRecordNotFound if it isn't a member of it
+func (conn *DBConn) GetProjectRole(id int, userID int) (string, error) {
+	var member model.ProjectMember
+	if result := conn.DB.Where("project_id = ? AND user_id = ?", id, userID).First(&member); result.Error != nil {
+		return "", result.Error
+	}
+	return member.Role, nil
+}
+
+// UpdateProject updates the name and description of an existing project, and returns it with the role of the user
+// with the id <userID>. Returns gorm.ErrRecordNotFound if there is no such project
+func (conn *DBConn) UpdateProject(p model.Project, userID int) (model.Project, error) {
+	result := conn.DB.Model(&p).Select("name", "description", "updated_at").Updates(p)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"project_id": p.ID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't update project")
+		return model.Project{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return model.Project{}, gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": p.ID,
+	}).Infoln("[DB] Updated existing project")
+	return conn.GetProject(p.ID, userID)
+}
+
+// DeleteProject deletes the project with the specified id, along with its tasks and their comments and attachments
+// (whose content must be deleted from the blob store separately), members and invitations, on the same transaction.
+// Returns gorm.ErrRecordNotFound if there is no such project
+func (conn *DBConn) DeleteProject(id int) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"DELETE FROM task_tags WHERE task_id IN (SELECT id FROM tasks WHERE project_id = @id)",
+			"DELETE FROM task_dependencies WHERE task_id IN (SELECT id FROM tasks WHERE project_id = @id) " +
+				"OR blocked_by_id IN (SELECT id FROM tasks WHERE project_id = @id)",
+			"DELETE FROM task_comments WHERE task_id IN (SELECT id FROM tasks WHERE project_id = @id)",
+			"DELETE FROM attachments WHERE task_id IN (SELECT id FROM tasks WHERE project_id = @id)",
+			"DELETE FROM tasks WHERE project_id = @id",
+			"DELETE FROM project_members WHERE project_id = @id",
+			"DELETE FROM project_invitations WHERE project_id = @id",
+		}
+		for _, statement := range statements {
+			if result := tx.Exec(statement, map[string]interface{}{"id": id}); result.Error != nil {
+				return result.Error
+			}
+		}
+		result := tx.Delete(&model.Project{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"project_id": id,
+				"error": err,
+			}).Errorln("[DB] Couldn't delete project by id")
+		}
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Deleted existing project")
+	return nil
+}
+
+// GetProjectMembers returns all the members of the project with the specified id, along with their user details,
+// leaving out the users on the trash
+func (conn *DBConn) GetProjectMembers(id int) ([]model.ProjectMemberDetails, error) {
+	var members []model.ProjectMemberDetails
+	result := conn.DB.Table("project_members").
+		Select("project_members.*, users.email, users.first_name, users.last_name").
+		Joins("JOIN users ON users.id = project_members.user_id AND users.deleted_at IS NULL").
+		Where("project_members.project_id = ?", id).Order("project_members.created_at, users.id").Scan(&members)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"project_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get project members")
+		return []model.ProjectMemberDetails{}, result.Error
+	}
+	return members, nil
+}
+
+// UpdateProjectMember changes the role of a member of a project. Returns gorm.ErrRecordNotFound if there is no such
+// member, or model.ErrLastProjectOwner if it's the only owner and would be demoted
+func (conn *DBConn) UpdateProjectMember(m model.ProjectMember) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		if m.Role != model.ProjectRoleOwner {
+			if err := checkOtherProjectOwner(tx, m.ProjectID, m.UserID); err != nil {
+				return err
+			}
+		}
+		result := tx.Model(&model.ProjectMember{}).Where("project_id = ? AND user_id = ?", m.ProjectID, m.UserID).
+			Update("role", m.Role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrLastProjectOwner) {
+			logging.Logger.WithFields(logrus.Fields{
+				"project_id": m.ProjectID,
+				"user_id": m.UserID,
+				"error": err,
+			}).Errorln("[DB] Couldn't update project member")
+		}
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"project_id": m.ProjectID,
+		"user_id": m.UserID,
+		"role": m.Role,
+	}).Infoln("[DB] Updated project member")
+	return nil
+}
+
+// DeleteProjectMember removes a member from a project. Its tasks stay on the project. Returns gorm.ErrRecordNotFound
+// if there is no such member, or model.ErrLastProjectOwner if it's the only owner
+func (conn *DBConn) DeleteProjectMember(id int, userID int) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkOtherProjectOwner(tx, id, userID); err != nil {
+			return err
+		}
+		result := tx.Where("project_id = ? AND user_id = ?", id, userID).Delete(&model.ProjectMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrLastProjectOwner) {
+			logging.Logger.WithFields(logrus.Fields{
+				"project_id": id,
+				"user_id": userID,
+				"error": err,
+			}).Errorln("[DB] Couldn't delete project member")
+		}
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"project_id": id,
+		"user_id": userID,
+	}).Infoln("[DB] Deleted project member")
+	return nil
+}
+
+// checkOtherProjectOwner checks if the project with the specified id has an owner other than the user with the id
+// <userID>, locking the owners until the transaction ends. Returns model.ErrLastProjectOwner if it doesn't
+func checkOtherProjectOwner(tx *gorm.DB, id int, userID int) error {
+	var owners []model.ProjectMember
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("project_id = ? AND role = ?", id, model.ProjectRoleOwner).Find(&owners)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, owner := range owners {
+		if owner.UserID != userID {
+			return nil
+		}
+	}
+	if len(owners) > 0 {
+		return model.ErrLastProjectOwner
+	}
+	return nil
+}
+
+// CreateProjectInvitation registers a new invitation to a project on the database. Pending invitations previously
+// sent to the same email for the same project are deleted, so only the latest one can be accepted
+func (conn *DBConn) CreateProjectInvitation(inv model.ProjectInvitation) (model.ProjectInvitation, error) {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		dr := tx.Delete(model.ProjectInvitation{}, "project_id = ? AND lower(email) = ? AND accepted_at IS NULL",
+			inv.ProjectID, strings.ToLower(inv.Email))
+		if dr.Error != nil {
+			return dr.Error
+		}
+		return tx.Create(&inv).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"project_id": inv.ProjectID,
+			"error": err,
+		}).Errorln("[DB] Couldn't create project invitation")
+		return model.ProjectInvitation{}, err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": inv.ID,
+		"project_id": inv.ProjectID,
+	}).Infoln("[DB] Created project invitation")
+	return inv, nil
+}
+
+// GetProjectInvitations returns the pending and unexpired invitations to the project with the specified id
+func (conn *DBConn) GetProjectInvitations(id int) ([]model.ProjectInvitation, error) {
+	var invitations []model.ProjectInvitation
+	result := conn.DB.Where("project_id = ? AND accepted_at IS NULL AND expires_at > ?", id, time.Now()).
+		Order("created_at").Find(&invitations)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"project_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get project invitations")
+		return []model.ProjectInvitation{}, result.Error
+	}
+	return invitations, nil
+}
+
+// DeleteProjectInvitation deletes a pending invitation to the project with the specified id. Returns
+// gorm.ErrRecordNotFound if there is no such invitation
+func (conn *DBConn) DeleteProjectInvitation(id int, invitationID int) error {
+	result := conn.DB.Where("project_id = ? AND accepted_at IS NULL", id).Delete(&model.ProjectInvitation{}, invitationID)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"project_id": id,
+			"invitation_id": invitationID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't delete project invitation")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": invitationID,
+	}).Infoln("[DB] Deleted project invitation")
+	return nil
+}
+
+// GetProjectInvitation returns the pending and unexpired project invitation with the hash <tokenHash>, or
+// gorm.ErrRecordNotFound if there is no such invitation.
+func (conn *DBConn) GetProjectInvitation(tokenHash string) (model.ProjectInvitation, error) {
+	var inv model.ProjectInvitation
+	result := conn.DB.Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&inv)
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"error": result.Error,
+			}).Errorln("[DB] Couldn't get project invitation")
+		}
+		return model.ProjectInvitation{}, result.Error
+	}
+	return inv, nil
+}
+
+// AcceptProjectInvitation marks the pending and unexpired invitation with the hash <tokenHash>, sent to the email of
+// <user>, as accepted and adds the user to the project with the invitation role, on the same transaction. Users that
+// are already members keep their role. Returns the membership, or gorm.ErrRecordNotFound if there is no such
+// invitation
+func (conn *DBConn) AcceptProjectInvitation(tokenHash string, user model.User) (model.ProjectMember, error) {
+	var member model.ProjectMember
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		var inv model.ProjectInvitation
+		fr := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, time.Now()).First(&inv)
+		if fr.Error != nil {
+			return fr.Error
+		}
+		if !strings.EqualFold(inv.Email, user.Email) {
+			return gorm.ErrRecordNotFound
+		}
+		if ur := tx.Model(&inv).Update("accepted_at", time.Now()); ur.Error != nil {
+			return ur.Error
+		}
+		member = model.ProjectMember{ProjectID: inv.ProjectID, UserID: user.ID, Role: inv.Role}
+		if cr := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member); cr.Error != nil {
+			return cr.Error
+		}
+		return tx.Where("project_id = ? AND user_id = ?", inv.ProjectID, user.ID).First(&member).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"user_id": user.ID,
+				"error": err,
+			}).Errorln("[DB] Couldn't accept project invitation")
+		}
+		return model.ProjectMember{}, err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"project_id": member.ProjectID,
+		"user_id": user.ID,
+	}).Infoln("[DB] Accepted project invitation")
+	return member, nil
+}
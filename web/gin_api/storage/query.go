@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+)
+
+var (
+	likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+)
+
+// paginate is a gorm scope that sorts a query and restricts it to the specified page. The sort column must be
+// validated beforehand, it's quoted but not checked against the table columns
+func paginate(page model.Page) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(page.Sort) > 0 {
+			db = db.Order(clause.OrderByColumn{
+				Column: clause.Column{Table: clause.CurrentTable, Name: page.Sort},
+				Desc:   page.Desc,
+			})
+		}
+		if page.Sort != "id" {
+			// keeps the order stable between pages when the sort column has repeated values
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: "id"}})
+		}
+		return db.Limit(page.Limit).Offset(page.Offset)
+	}
+}
+
+// containing returns a LIKE pattern that matches the values containing <s>, escaping its wildcards
+func containing(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// GetDueRecurringTasks returns up to <limit> recurring tasks, of every user, that are completed or due at <now> and
+// haven't recurred yet
+func (conn *DBConn) GetDueRecurringTasks(now time.Time, limit int) ([]model.Task, error) {
+	var tasks []model.Task
+	result := conn.DB.Where("tasks.recurrence <> '' AND tasks.recurred_at IS NULL").
+		Where("(tasks.completed = ? OR tasks.due_at <= ?)", true, now).
+		Order("tasks.id").Limit(limit).Preload("Tags").Find(&tasks)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get due recurring tasks")
+		return []model.Task{}, result.Error
+	}
+	return tasks, nil
+}
+
+// RecurTask marks a recurring task as recurred and creates its next occurrence (<next>), with its tags
+// (<next.TagIDs>), if it isn't nil, on the same transaction. Returns false, without creating it, if the task was
+// already marked, so each task recurs only once
+func (conn *DBConn) RecurTask(t model.Task, next *model.Task) (bool, error) {
+	recurred := false
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Task{}).Where("id = ? AND recurred_at IS NULL", t.ID).
+			UpdateColumn("recurred_at", time.Now())
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		recurred = true
+		if next == nil {
+			return nil
+		}
+		next.Tags = nil
+		if result := tx.Create(next); result.Error != nil {
+			return result.Error
+		}
+		return replaceTaskTags(tx, next)
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": t.ID,
+			"error": err,
+		}).Errorln("[DB] Couldn't create next occurrence of task")
+		return false, err
+	}
+	if recurred && next != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"id": next.ID,
+			"recurred_from_id": t.ID,
+		}).Infoln("[DB] Created next occurrence of task")
+	}
+	return recurred, nil
+}
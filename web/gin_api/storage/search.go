@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	// taskSearchConfig is the Postgres text search configuration used to index and search task descriptions
+	taskSearchConfig = "english"
+	// highlightStart and highlightStop delimit the matching terms of a search, before the snippet is HTML escaped
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+var (
+	highlightReplacer = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+	headlineOptions   = fmt.Sprintf("StartSel=%v, StopSel=%v, HighlightAll=true", highlightStart, highlightStop)
+)
+
+// SearchTasks returns a page of the tasks the user with the specified id can read whose description matches the text of
+// <search>, along with the total number of matching tasks. It uses full-text search, ranking the results by
+// relevance, if the database supports it, or ILIKE matching of every search term otherwise (with a rank of 0)
+func (conn *DBConn) SearchTasks(userID int, search model.TaskSearch) ([]model.TaskSearchResult, int64, error) {
+	var (
+		results []model.TaskSearchResult
+		total   int64
+	)
+	if len(strings.Fields(search.Query)) == 0 {
+		return []model.TaskSearchResult{}, 0, nil
+	}
+
+	matching, selection := tasksMatchingTerms(search.Query), conn.DB.Select("tasks.*, 0 AS rank")
+	if conn.FullTextSearch {
+		matching = tasksMatchingSearch(search.Query)
+		selection = conn.DB.Select(fmt.Sprintf("tasks.*, ts_rank(tasks.search_vector, search_query) AS rank, "+
+			"ts_headline('%v', tasks.description, search_query, ?) AS snippet", taskSearchConfig), headlineOptions)
+	}
+
+	filtered := conn.DB.Model(&model.Task{}).Scopes(tasksVisibleTo(userID), tasksInProject(search.ProjectID), matching)
+	if result := filtered.Count(&total); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't count matching tasks")
+		return []model.TaskSearchResult{}, 0, result.Error
+	}
+
+	page, ordered := search.Page, selection.Model(&model.Task{})
+	if page.Sort == "rank" {
+		if conn.FullTextSearch {
+			ordered = ordered.Order(clause.OrderByColumn{Column: clause.Column{Name: "rank", Raw: true}, Desc: page.Desc})
+		}
+		page.Sort = "id"
+	}
+	// Scan doesn't leave out the trashed tasks like Find and Count do, so they're filtered explicitly
+	result := ordered.Where("tasks.deleted_at IS NULL").
+		Scopes(tasksVisibleTo(userID), tasksInProject(search.ProjectID), matching, paginate(page)).Scan(&results)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't search tasks")
+		return []model.TaskSearchResult{}, 0, result.Error
+	}
+
+	tasks := make([]*model.Task, len(results))
+	for i := range results {
+		tasks[i] = &results[i].Task
+		if !conn.FullTextSearch {
+			results[i].Snippet = highlightTerms(results[i].Description, search.Query)
+		}
+		results[i].Snippet = highlightReplacer.Replace(html.EscapeString(results[i].Snippet))
+	}
+	err := conn.attachTaskTags(tasks)
+	if err == nil {
+		err = conn.attachTaskBlockers(tasks)
+	}
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": err,
+		}).Errorln("[DB] Couldn't get tags and blockers of matching tasks")
+		return []model.TaskSearchResult{}, 0, err
+	}
+	return results, total, nil
+}
+
+// tasksMatchingSearch is a gorm scope that restricts a query to the tasks that match the full-text search <query>,
+// which supports the web search syntax (quoted phrases, "or" and "-" to exclude terms). The parsed query is available
+// to the selected columns as search_query
+func tasksMatchingSearch(query string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Joins(fmt.Sprintf("CROSS JOIN websearch_to_tsquery('%v', ?) AS search_query", taskSearchConfig), query).
+			Where("tasks.search_vector @@ search_query")
+	}
+}
+
+// tasksMatchingTerms is a gorm scope that restricts a query to the tasks whose description contains every term of
+// <query>, ignoring case
+func tasksMatchingTerms(query string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, term := range strings.Fields(query) {
+			db = db.Where("tasks.description ILIKE ?", containing(term))
+		}
+		return db
+	}
+}
+
+// highlightTerms delimits the occurrences of every term of <query> in <text> with highlightStart and highlightStop,
+// ignoring case
+func highlightTerms(text string, query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+	pattern := regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+	return pattern.ReplaceAllString(text, highlightStart+"$0"+highlightStop)
+}
@@ -5,6 +5,9 @@ import (
 	"github.com/jomifepe/gin_api/logging"
 	"github.com/jomifepe/gin_api/model"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
 )
 
 type TaskStore struct {
@@ -14,24 +17,85 @@ type TaskStore struct {
 // NewAuthStore return an AuthStore.
 func NewTaskStore(conn *DBConn) *TaskStore {
 	return &TaskStore{
-		DBConn{DB: conn.DB},
+		DBConn{DB: conn.DB, FullTextSearch: conn.FullTextSearch},
 	}
 }
 
+// tasksVisibleTo is a gorm scope that restricts a query to the tasks the user with the specified id can read: its
+// personal tasks and the tasks of the projects it's a member of
+func tasksVisibleTo(userID int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("((tasks.project_id IS NULL AND tasks.user_id = ?) OR tasks.project_id IN "+
+			"(SELECT project_id FROM project_members WHERE user_id = ?))", userID, userID)
+	}
+}
+
+// tasksWritableBy is a gorm scope that restricts a query to the tasks the user with the specified id can write: its
+// personal tasks and the tasks of the projects it's an owner or editor of
+func tasksWritableBy(userID int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("((tasks.project_id IS NULL AND tasks.user_id = ?) OR tasks.project_id IN "+
+			"(SELECT project_id FROM project_members WHERE user_id = ? AND role IN ?))", userID, userID,
+			[]string{model.ProjectRoleOwner, model.ProjectRoleEditor})
+	}
+}
+
+// tasksInProject is a gorm scope that restricts a query to the tasks of the project with the specified id, or to the
+// personal tasks if it's 0. Nil doesn't restrict it
+func tasksInProject(projectID *int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if projectID == nil {
+			return db
+		}
+		if *projectID == 0 {
+			return db.Where("tasks.project_id IS NULL")
+		}
+		return db.Where("tasks.project_id = ?", *projectID)
+	}
+}
+
+// CreateTask registers a new task on the database, along with its tags (<t.TagIDs>) and blockers (<t.BlockedBy>), on
+// the same transaction, and records it on its activity log as created by the user with the id <t.UserID>. Returns
+// model.ErrTaskLinkNotFound if its parent or blockers aren't tasks of the same user, or model.ErrTaskBlocked if it's
+// completed while any of its blockers aren't
 func (conn *DBConn) CreateTask(t model.Task) (model.Task, error) {
-	result := conn.DB.Create(&t)
-	if result.Error != nil {
-		logging.Logger.WithFields(logrus.Fields{
-			"error": result.Error,
-			"task": t,
-		}).Errorln("[DB] Couldn't create task")
-		return model.Task{}, result.Error
+	t.Tags = []model.Tag{}
+	t.Version, t.DeletedAt = 1, gorm.DeletedAt{}
+	if t.BlockedBy == nil {
+		t.BlockedBy = []int{}
 	}
-	if result.RowsAffected <= 0 {
-		logging.Logger.WithFields(logrus.Fields{
-			"task": t,
-		}).Errorln("[DB] No rows were affected when creating task")
-		return model.Task{}, errors.New("now rows were affected")
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkTaskLinks(tx, t); err != nil {
+			return err
+		}
+		result := tx.Create(&t)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected <= 0 {
+			return errors.New("now rows were affected")
+		}
+		if err := replaceTaskBlockers(tx, &t); err != nil {
+			return err
+		}
+		if t.Completed {
+			if err := checkTaskUnblocked(tx, t.ID); err != nil {
+				return err
+			}
+		}
+		if err := replaceTaskTags(tx, &t); err != nil {
+			return err
+		}
+		return recordTaskActivity(tx, t.UserID, model.TaskActionCreate, model.Task{}, t)
+	})
+	if err != nil {
+		if !isTaskRuleError(err) {
+			logging.Logger.WithFields(logrus.Fields{
+				"error": err,
+				"task": t,
+			}).Errorln("[DB] Couldn't create task")
+		}
+		return model.Task{}, err
 	}
 	logging.Logger.WithFields(logrus.Fields{
 		"id": t.ID,
@@ -39,23 +103,102 @@ func (conn *DBConn) CreateTask(t model.Task) (model.Task, error) {
 	return t, nil
 }
 
-func (conn *DBConn) GetAllTasks() ([]model.Task, error) {
-	var tasks []model.Task
-	result := conn.DB.Find(&tasks)
+// replaceTaskTags replaces the tags of a task with the tags of its user with the ids <t.TagIDs>, unless they're nil.
+// Sets <t.Tags> to the new tags
+func replaceTaskTags(tx *gorm.DB, t *model.Task) error {
+	if t.TagIDs == nil {
+		return nil
+	}
+	tags := []model.Tag{}
+	if len(t.TagIDs) > 0 {
+		if result := tx.Where("id IN ? AND user_id = ?", t.TagIDs, t.UserID).Find(&tags); result.Error != nil {
+			return result.Error
+		}
+	}
+	if err := tx.Model(t).Association("Tags").Replace(tags); err != nil {
+		return err
+	}
+	t.Tags = tags
+	return nil
+}
+
+// GetAllTasks returns a page of the tasks the user with the specified id can read that match the filters of <query>,
+// along with the total number of matching tasks
+func (conn *DBConn) GetAllTasks(userID int, query model.TaskQuery) ([]model.Task, int64, error) {
+	var (
+		tasks []model.Task
+		total int64
+	)
+	filtered := conn.DB.Model(&model.Task{}).Scopes(tasksVisibleTo(userID), tasksMatching(query))
+	if result := filtered.Count(&total); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't count tasks")
+		return []model.Task{}, 0, result.Error
+	}
+	result := conn.DB.Scopes(tasksVisibleTo(userID), tasksMatching(query), paginate(query.Page)).
+		Preload("Tags").Find(&tasks)
+	if result.Error == nil {
+		pointers := make([]*model.Task, len(tasks))
+		for i := range tasks {
+			pointers[i] = &tasks[i]
+		}
+		result.Error = conn.attachTaskBlockers(pointers)
+	}
 	if result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
 			"error": result.Error,
 		}).Errorln("[DB] Couldn't get all tasks")
-		return []model.Task{}, result.Error
+		return []model.Task{}, 0, result.Error
+	}
+	return tasks, total, nil
+}
+
+// tasksMatching is a gorm scope that restricts a query to the tasks that match the filters of <query>
+func tasksMatching(query model.TaskQuery) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = tasksInProject(query.ProjectID)(db)
+		if query.Completed != nil {
+			db = db.Where("tasks.completed = ?", *query.Completed)
+		}
+		if query.CreatedAfter != nil {
+			db = db.Where("tasks.created_at >= ?", *query.CreatedAfter)
+		}
+		if query.CreatedBefore != nil {
+			db = db.Where("tasks.created_at < ?", *query.CreatedBefore)
+		}
+		if len(query.Description) > 0 {
+			db = db.Where("tasks.description ILIKE ?", containing(query.Description))
+		}
+		if len(query.Tag) > 0 {
+			db = db.Where("EXISTS (SELECT 1 FROM task_tags JOIN tags ON tags.id = task_tags.tag_id "+
+				"WHERE task_tags.task_id = tasks.id AND tags.name = ?)", query.Tag)
+		}
+		if query.Priority != nil {
+			db = db.Where("tasks.priority = ?", *query.Priority)
+		}
+		if query.Overdue != nil && *query.Overdue {
+			db = db.Where("tasks.completed = ? AND tasks.due_at < ?", false, time.Now())
+		} else if query.Overdue != nil {
+			db = db.Where("(tasks.completed = ? OR tasks.due_at IS NULL OR tasks.due_at >= ?)", true, time.Now())
+		}
+		return db
 	}
-	return tasks, nil
 }
 
-func (conn *DBConn) GetTask(id int) (model.Task, error) {
+// GetTask returns the task with the specified id, if the user with the id <userID> can read it
+func (conn *DBConn) GetTask(id int, userID int) (model.Task, error) {
 	var task model.Task
-	if result := conn.DB.First(&task, "id = ?", id); result.Error != nil {
+	result := conn.DB.Scopes(tasksVisibleTo(userID)).Preload("Tags").First(&task, "tasks.id = ?", id)
+	if result.Error == nil {
+		result.Error = conn.attachTaskBlockers([]*model.Task{&task})
+	}
+	if result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
 			"task_id": id,
+			"user_id": userID,
 			"error": result.Error,
 		}).Errorln("[DB] Couldn't get task by id")
 		return model.Task{}, result.Error
@@ -63,21 +206,57 @@ func (conn *DBConn) GetTask(id int) (model.Task, error) {
 	return task, nil
 }
 
-func (conn *DBConn) UpdateTask(t model.Task) (model.Task, error) {
-	result := conn.DB.Model(&t).Select("description", "completed", "updated_at").Updates(t)
-	if result.Error != nil && result.RowsAffected > 0 {
+// GetTasksByID returns the tasks with the specified ids that the user with the id <userID> can read, in no particular
+// order
+func (conn *DBConn) GetTasksByID(ids []int, userID int) ([]model.Task, error) {
+	tasks := []model.Task{}
+	if len(ids) == 0 {
+		return tasks, nil
+	}
+	result := conn.DB.Scopes(tasksVisibleTo(userID)).Preload("Tags").Find(&tasks, "tasks.id IN ?", ids)
+	if result.Error == nil {
+		pointers := make([]*model.Task, len(tasks))
+		for i := range tasks {
+			pointers[i] = &tasks[i]
+		}
+		result.Error = conn.attachTaskBlockers(pointers)
+	}
+	if result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
-			"task": t,
+			"user_id": userID,
 			"error": result.Error,
-		}).Errorln("[DB] Couldn't update task")
-		return model.Task{}, result.Error
+		}).Errorln("[DB] Couldn't get tasks by id")
+		return []model.Task{}, result.Error
 	}
-	updatedTask, err := conn.GetTask(t.ID)
+	return tasks, nil
+}
+
+// UpdateTask updates the specified columns of an existing task, or all the ones that can be changed if none are
+// specified, if the user with the id <t.UserID> can write it, and replaces its tags and blockers if <t.TagIDs> and
+// <t.BlockedBy> aren't nil, on the same transaction, incrementing its version. If <t.Version> isn't 0, the task is only
+// updated if that's still its version. Tasks stay on the project they were created on. Returns gorm.ErrRecordNotFound
+// if the user has no such task, model.ErrVersionConflict if it was changed since <t.Version>, model.ErrTaskLinkNotFound
+// or model.ErrTaskCycle if its parent or blockers are invalid, or model.ErrTaskBlocked if it's being completed while
+// any of its blockers aren't
+func (conn *DBConn) UpdateTask(t model.Task, columns ...string) (model.Task, error) {
+	err := conn.changeTask(t.ID, t.UserID, t.Version, model.TaskActionUpdate, func(tx *gorm.DB) error {
+		return updateTask(tx, &t, columns)
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrVersionConflict) && !isTaskRuleError(err) {
+			logging.Logger.WithFields(logrus.Fields{
+				"task": t,
+				"error": err,
+			}).Errorln("[DB] Couldn't update task")
+		}
+		return model.Task{}, err
+	}
+	updatedTask, err := conn.GetTask(t.ID, t.UserID)
 	if err != nil {
 		logging.Logger.WithFields(logrus.Fields{
 			"error": err,
 		}).Errorln("[DB] Couldn't get updated task")
-		return model.Task{}, result.Error
+		return model.Task{}, err
 	}
 	logging.Logger.WithFields(logrus.Fields{
 		"id": t.ID,
@@ -85,16 +264,360 @@ func (conn *DBConn) UpdateTask(t model.Task) (model.Task, error) {
 	return updatedTask, nil
 }
 
-func (conn *DBConn) DeleteTask(id int) error {
-	if result := conn.DB.Delete(&model.Task{}, id); result.Error != nil && result.RowsAffected > 0 {
+// updateTask updates the specified columns of an existing task on the transaction <tx>, like UpdateTask, setting its
+// new version and tags on <t>
+func updateTask(tx *gorm.DB, t *model.Task, columns []string) error {
+	if len(columns) == 0 {
+		columns = []string{"description", "completed", "due_at", "priority", "notes", "parent_id", "recurrence"}
+	}
+	t.Tags = nil
+	current, err := lockWritableTask(tx, t.ID, t.UserID, t.Version)
+	if err != nil {
+		return err
+	}
+	t.ProjectID, t.Version, t.RecurrenceDay = current.ProjectID, current.Version+1, current.RecurrenceDay
+	if t.Recurrence != current.Recurrence || dueDay(t.DueAt) != dueDay(current.DueAt) {
+		t.RecurrenceDay = 0
+	}
+	if t.DetachedFromID = current.DetachedFromID; t.ParentID != nil {
+		t.DetachedFromID = nil
+	}
+	if err := checkTaskLinks(tx, *t); err != nil {
+		return err
+	}
+	// the recurrence day, detached parent, version and update date are always written, unless they're already specified
+	selected := append([]string{}, columns...)
+	for _, column := range []string{"recurrence_day", "detached_from_id", "version", "updated_at"} {
+		if !containsColumn(selected, column) {
+			selected = append(selected, column)
+		}
+	}
+	result := tx.Model(t).Scopes(tasksWritableBy(t.UserID)).Select(selected).Updates(t)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	if err := replaceTaskBlockers(tx, t); err != nil {
+		return err
+	}
+	if t.Completed && !current.Completed {
+		if err := checkTaskUnblocked(tx, t.ID); err != nil {
+			return err
+		}
+	}
+	return replaceTaskTags(tx, t)
+}
+
+// containsColumn returns true if <column> is one of <columns>
+func containsColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// dueDay returns the day of the month of a due date, or 0 if there's none
+func dueDay(dueAt *time.Time) int {
+	if dueAt == nil {
+		return 0
+	}
+	return dueAt.Day()
+}
+
+// DeleteTask moves the task with the specified id to the trash, if the user with the id <userID> can write it and,
+// unless <version> is 0, if that's still its version. Its tags, dependencies, comments and attachments are kept until
+// it's purged, so it can be restored. Its subtasks become top-level tasks, until it's restored. Returns
+// gorm.ErrRecordNotFound if the user has no such task, or if it's already on the trash, or model.ErrVersionConflict if
+// it was changed since <version>.
+func (conn *DBConn) DeleteTask(id int, userID int, version int) error {
+	err := conn.changeTask(id, userID, version, model.TaskActionDelete, func(tx *gorm.DB) error {
+		return deleteTask(tx, id, userID, version)
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrVersionConflict) {
+			logging.Logger.WithFields(logrus.Fields{
+				"task_id": id,
+				"user_id": userID,
+				"error": err,
+			}).Errorln("[DB] Couldn't delete task by id")
+		}
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Moved existing task to the trash")
+	return nil
+}
+
+// deleteTask moves the task with the specified id to the trash on the transaction <tx>, like DeleteTask
+func deleteTask(tx *gorm.DB, id int, userID int, version int) error {
+	if _, err := lockWritableTask(tx, id, userID, version); err != nil {
+		return err
+	}
+	if dr := tx.Exec("UPDATE tasks SET parent_id = NULL, detached_from_id = ?, version = version + 1 "+
+		"WHERE parent_id = ?", id, id); dr.Error != nil {
+		return dr.Error
+	}
+	result := tx.Where("deleted_at IS NULL").Delete(&model.Task{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ToggleTask toggles the completed field of the task with the specified id, if the user with the id <userID> can write
+// it, incrementing its version. The task is locked while it's toggled, so concurrent toggles are applied one after the
+// other. If <version> isn't 0, the task is only toggled if that's still its version. Returns gorm.ErrRecordNotFound
+// if the user has no such task, model.ErrVersionConflict if it was changed since <version>, or model.ErrTaskBlocked if
+// it's being completed while any of its blockers aren't
+func (conn *DBConn) ToggleTask(id int, userID int, version int) (model.Task, error) {
+	err := conn.changeTask(id, userID, version, model.TaskActionToggle, func(tx *gorm.DB) error {
+		return toggleTask(tx, id, userID, version)
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrVersionConflict) && !isTaskRuleError(err) {
+			logging.Logger.WithFields(logrus.Fields{
+				"task_id": id,
+				"user_id": userID,
+				"error": err,
+			}).Errorln("[DB] Couldn't toggle task")
+		}
+		return model.Task{}, err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Toggled existing task")
+	return conn.GetTask(id, userID)
+}
+
+// toggleTask toggles the completed field of the task with the specified id on the transaction <tx>, like ToggleTask
+func toggleTask(tx *gorm.DB, id int, userID int, version int) error {
+	current, err := lockWritableTask(tx, id, userID, version)
+	if err != nil {
+		return err
+	}
+	if !current.Completed {
+		if err := checkTaskUnblocked(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Model(&model.Task{ID: id}).Updates(map[string]interface{}{
+		"completed": !current.Completed,
+		"version": gorm.Expr("version + 1"),
+	}).Error
+}
+
+// changeTask applies <change> to the task with the specified id on a new transaction, like changeTaskOn
+func (conn *DBConn) changeTask(id int, userID int, version int, action string, change func(tx *gorm.DB) error) error {
+	return conn.DB.Transaction(func(tx *gorm.DB) error {
+		return changeTaskOn(tx, id, userID, version, action, change)
+	})
+}
+
+// changeTaskOn applies <change> to the task with the specified id on the transaction <tx>, after locking it if the
+// user with the id <userID> can write it (see lockWritableTask), and records it on the task activity log as the
+// action <action> of that user, with the fields it changed, on the same transaction
+func changeTaskOn(tx *gorm.DB, id int, userID int, version int, action string, change func(tx *gorm.DB) error) error {
+	if _, err := lockWritableTask(tx, id, userID, version); err != nil {
+		return err
+	}
+	before, err := loadTask(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := change(tx); err != nil {
+		return err
+	}
+	after := model.Task{}
+	if action != model.TaskActionDelete {
+		if after, err = loadTask(tx, id); err != nil {
+			return err
+		}
+	}
+	return recordTaskActivity(tx, userID, action, before, after)
+}
+
+// loadTask returns the task with the specified id, even if it's on the trash, along with its tags and blockers, on
+// the transaction <tx>
+func loadTask(tx *gorm.DB, id int) (model.Task, error) {
+	var task model.Task
+	if result := tx.Unscoped().Preload("Tags").First(&task, "tasks.id = ?", id); result.Error != nil {
+		return model.Task{}, result.Error
+	}
+	err := (&DBConn{DB: tx}).attachTaskBlockers([]*model.Task{&task})
+	return task, err
+}
+
+// lockWritableTask locks the task with the specified id until the transaction ends, if the user with the id <userID>
+// can write it, and returns its completed, project and version fields. Returns gorm.ErrRecordNotFound if the user has
+// no such task, or model.ErrVersionConflict if <version> isn't 0 nor the task version
+func lockWritableTask(tx *gorm.DB, id int, userID int, version int) (model.Task, error) {
+	var task model.Task
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(tasksWritableBy(userID)).
+		Select("id", "completed", "project_id", "due_at", "recurrence", "recurrence_day", "detached_from_id", "version").
+		First(&task, "tasks.id = ?", id)
+	if result.Error != nil {
+		return model.Task{}, result.Error
+	}
+	if version != 0 && task.Version != version {
+		return model.Task{}, model.ErrVersionConflict
+	}
+	return task, nil
+}
+
+// CreateTag registers a new tag on the database
+func (conn *DBConn) CreateTag(tag model.Tag) (model.Tag, error) {
+	if result := conn.DB.Create(&tag); result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
-			"task_id": id,
 			"error": result.Error,
-		}).Errorln("[DB] Couldn't delete task by id")
-		return result.Error
+			"tag": tag,
+		}).Errorln("[DB] Couldn't create tag")
+		return model.Tag{}, result.Error
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": tag.ID,
+	}).Infoln("[DB] Created new tag")
+	return tag, nil
+}
+
+// GetTags returns all the tags of the user with the specified id, sorted by name
+func (conn *DBConn) GetTags(userID int) ([]model.Tag, error) {
+	var tags []model.Tag
+	if result := conn.DB.Where("user_id = ?", userID).Order("name").Find(&tags); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get all tags")
+		return []model.Tag{}, result.Error
+	}
+	return tags, nil
+}
+
+// GetTagsByID returns the tags with the specified ids that belong to the user with the id <userID>
+func (conn *DBConn) GetTagsByID(userID int, ids []int) ([]model.Tag, error) {
+	var tags []model.Tag
+	if result := conn.DB.Where("id IN ? AND user_id = ?", ids, userID).Find(&tags); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get tags by id")
+		return []model.Tag{}, result.Error
+	}
+	return tags, nil
+}
+
+// GetTag returns the tag with the specified id, if it belongs to the user with the id <userID>
+func (conn *DBConn) GetTag(id int, userID int) (model.Tag, error) {
+	var tag model.Tag
+	if result := conn.DB.Where("user_id = ?", userID).First(&tag, "id = ?", id); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"tag_id": id,
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get tag by id")
+		return model.Tag{}, result.Error
+	}
+	return tag, nil
+}
+
+// GetTagByName returns the tag of the user with the id <userID> with the specified name
+func (conn *DBConn) GetTagByName(userID int, name string) (model.Tag, error) {
+	var tag model.Tag
+	if result := conn.DB.Where("user_id = ?", userID).First(&tag, "name = ?", name); result.Error != nil {
+		return model.Tag{}, result.Error
+	}
+	return tag, nil
+}
+
+// UpdateTag updates the name and color of an existing tag, if it belongs to the user with the id <tag.UserID>.
+// Returns gorm.ErrRecordNotFound if the user has no such tag.
+func (conn *DBConn) UpdateTag(tag model.Tag) (model.Tag, error) {
+	result := conn.DB.Model(&tag).Where("user_id = ?", tag.UserID).Select("name", "color").Updates(tag)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"tag": tag,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't update tag")
+		return model.Tag{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return model.Tag{}, gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": tag.ID,
+	}).Infoln("[DB] Updated existing tag")
+	return conn.GetTag(tag.ID, tag.UserID)
+}
+
+// DeleteTag deletes the tag with the specified id, if it belongs to the user with the id <userID>, removing it from
+// every task. Returns gorm.ErrRecordNotFound if the user has no such tag.
+func (conn *DBConn) DeleteTag(id int, userID int) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		dr := tx.Exec("DELETE FROM task_tags WHERE tag_id IN (SELECT id FROM tags WHERE id = ? AND user_id = ?)",
+			id, userID)
+		if dr.Error != nil {
+			return dr.Error
+		}
+		result := tx.Where("user_id = ?", userID).Delete(&model.Tag{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"tag_id": id,
+				"user_id": userID,
+				"error": err,
+			}).Errorln("[DB] Couldn't delete tag by id")
+		}
+		return err
 	}
 	logging.Logger.WithFields(logrus.Fields{
 		"id": id,
-	}).Infoln("[DB] Deleted existing task")
+	}).Infoln("[DB] Deleted existing tag")
 	return nil
-}
\ No newline at end of file
+}
+
+// taskTag is a tag along with the id of a task it's on
+type taskTag struct {
+	TaskID int
+	model.Tag
+}
+
+// attachTaskTags loads the tags of the specified tasks
+func (conn *DBConn) attachTaskTags(tasks []*model.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	var (
+		ids  = make([]int, len(tasks))
+		byID = make(map[int]*model.Task, len(tasks))
+		rows []taskTag
+	)
+	for i, t := range tasks {
+		ids[i], byID[t.ID] = t.ID, t
+		t.Tags = []model.Tag{}
+	}
+	result := conn.DB.Table("tags").Select("task_tags.task_id, tags.*").
+		Joins("JOIN task_tags ON task_tags.tag_id = tags.id").Where("task_tags.task_id IN ?", ids).
+		Order("tags.name").Scan(&rows)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, row := range rows {
+		byID[row.TaskID].Tags = append(byID[row.TaskID].Tags, row.Tag)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// GetTaskIncludingTrash returns the task with the specified id, even if it's on the trash, if the user with the id
+// <userID> can read it
+func (conn *DBConn) GetTaskIncludingTrash(id int, userID int) (model.Task, error) {
+	var task model.Task
+	if result := conn.DB.Unscoped().Scopes(tasksVisibleTo(userID)).First(&task, "tasks.id = ?", id); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"task_id": id,
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get task by id, including the trash")
+		return model.Task{}, result.Error
+	}
+	return task, nil
+}
+
+// GetUserByEmailIncludingTrash returns the user with the specified email, even if it's on the trash, since its email
+// can't be used by another user until it's purged. It omits its password
+func (conn *DBConn) GetUserByEmailIncludingTrash(email string) (model.User, error) {
+	var user model.User
+	if result := conn.DB.Unscoped().Omit("password").First(&user, "email = ?", email); result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"error": result.Error,
+			}).Errorln("[DB] Couldn't get user by email, including the trash")
+		}
+		return model.User{}, result.Error
+	}
+	return user, nil
+}
+
+// GetDeletedTasks returns a page of the tasks on the trash that the user with the specified id can write (and so,
+// restore), along with the total number of trashed tasks
+func (conn *DBConn) GetDeletedTasks(userID int, page model.Page) ([]model.Task, int64, error) {
+	var (
+		tasks []model.Task
+		total int64
+	)
+	trashed := conn.DB.Unscoped().Model(&model.Task{}).Scopes(tasksWritableBy(userID)).Where("tasks.deleted_at IS NOT NULL")
+	if result := trashed.Count(&total); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't count deleted tasks")
+		return []model.Task{}, 0, result.Error
+	}
+	result := conn.DB.Unscoped().Scopes(tasksWritableBy(userID), paginate(page)).
+		Where("tasks.deleted_at IS NOT NULL").Preload("Tags").Find(&tasks)
+	if result.Error == nil {
+		pointers := make([]*model.Task, len(tasks))
+		for i := range tasks {
+			pointers[i] = &tasks[i]
+		}
+		result.Error = conn.attachTaskBlockers(pointers)
+	}
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get deleted tasks")
+		return []model.Task{}, 0, result.Error
+	}
+	return tasks, total, nil
+}
+
+// RestoreTask takes the task with the specified id out of the trash, if the user with the id <userID> can write it,
+// along with its tags, dependencies, comments and attachments, incrementing its version. Its subtasks are reattached
+// to it (see reattachSubtasks). It's recorded on its activity log as restored by that user, on the same transaction.
+// Returns gorm.ErrRecordNotFound if the user has no such task on the trash.
+func (conn *DBConn) RestoreTask(id int, userID int) (model.Task, error) {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&model.Task{}).Scopes(tasksWritableBy(userID)).
+			Where("tasks.id = ? AND tasks.deleted_at IS NOT NULL", id).
+			UpdateColumns(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := reattachSubtasks(tx, id); err != nil {
+			return err
+		}
+		restored, err := loadTask(tx, id)
+		if err != nil {
+			return err
+		}
+		return recordTaskActivity(tx, userID, model.TaskActionRestore, model.Task{}, restored)
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Logger.WithFields(logrus.Fields{
+				"task_id": id,
+				"user_id": userID,
+				"error": err,
+			}).Errorln("[DB] Couldn't restore task by id")
+		}
+		return model.Task{}, err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Restored task from the trash")
+	return conn.GetTask(id, userID)
+}
+
+// reattachSubtasks sets the task with the specified id back as the parent of the subtasks that were detached from it
+// when it was moved to the trash, incrementing their version, unless they became one of its ancestors since then
+func reattachSubtasks(tx *gorm.DB, id int) error {
+	var subtaskIDs []int
+	result := tx.Unscoped().Model(&model.Task{}).Where("detached_from_id = ?", id).Pluck("id", &subtaskIDs)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, subtaskID := range subtaskIDs {
+		var count int64
+		if err := tx.Raw(ancestorsQuery, id, subtaskID).Row().Scan(&count); err != nil {
+			return err
+		}
+		changes := map[string]interface{}{"detached_from_id": nil}
+		if count == 0 {
+			changes["parent_id"], changes["version"] = id, gorm.Expr("version + 1")
+		}
+		if result = tx.Unscoped().Model(&model.Task{}).Where("id = ?", subtaskID).UpdateColumns(changes); result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// GetDeletedUsers returns a page of the users on the trash, along with the total number of trashed users. It omits
+// their passwords
+func (conn *DBConn) GetDeletedUsers(page model.Page) ([]model.User, int64, error) {
+	var (
+		users []model.User
+		total int64
+	)
+	trashed := conn.DB.Unscoped().Model(&model.User{}).Where("users.deleted_at IS NOT NULL")
+	if result := trashed.Count(&total); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't count deleted users")
+		return []model.User{}, 0, result.Error
+	}
+	result := conn.DB.Unscoped().Omit("password").Scopes(paginate(page)).
+		Where("users.deleted_at IS NOT NULL").Find(&users)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get deleted users")
+		return []model.User{}, 0, result.Error
+	}
+	return users, total, nil
+}
+
+// RestoreUser takes the user with the specified id out of the trash, incrementing its version. Returns
+// gorm.ErrRecordNotFound if there's no such user on the trash.
+func (conn *DBConn) RestoreUser(id int) (model.User, error) {
+	result := conn.DB.Unscoped().Model(&model.User{}).Where("users.id = ? AND users.deleted_at IS NOT NULL", id).
+		UpdateColumns(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't restore user by id")
+		return model.User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return model.User{}, gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Restored user from the trash")
+	return conn.GetUserBy("id", id)
+}
+
+// PurgeDeletedTasks permanently deletes up to <limit> tasks that were moved to the trash before <before>, along with
+// their tag associations, dependencies, comments and attachments (their activity log is kept). Returns the deleted
+// attachments, whose content must be deleted from the blob store separately, and how many tasks were deleted
+func (conn *DBConn) PurgeDeletedTasks(before time.Time, limit int) ([]model.Attachment, int, error) {
+	var (
+		ids         []int
+		attachments []model.Attachment
+	)
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&model.Task{}).Where("deleted_at < ?", before).Order("id").Limit(limit).
+			Pluck("id", &ids)
+		if result.Error != nil {
+			return result.Error
+		}
+		var err error
+		attachments, err = purgeTasks(tx, ids)
+		return err
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"before": before,
+			"error": err,
+		}).Errorln("[DB] Couldn't purge deleted tasks")
+		return nil, 0, err
+	}
+	if len(ids) > 0 {
+		logging.Logger.WithFields(logrus.Fields{
+			"ids": ids,
+		}).Infoln("[DB] Purged deleted tasks")
+	}
+	return attachments, len(ids), nil
+}
+
+// PurgeDeletedUsers permanently deletes up to <limit> users that were moved to the trash before <before>, along with
+// their personal tasks and tags, sessions, API keys, recovery codes, password resets and project memberships. Users
+// that are the last member of a project, not counting the ones on the trash, are kept, so that the project isn't left
+// without members, and the projects left without an owner are handed to another member (see promoteProjectOwners).
+// Returns the attachments of the deleted tasks, whose content must be deleted from the blob store separately, and how
+// many users were deleted
+func (conn *DBConn) PurgeDeletedUsers(before time.Time, limit int) ([]model.Attachment, int, error) {
+	var (
+		ids         []int
+		attachments []model.Attachment
+	)
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&model.User{}).Where("deleted_at < ?", before).
+			Where("NOT EXISTS (SELECT 1 FROM project_members WHERE project_members.user_id = users.id AND NOT EXISTS (" +
+				"SELECT 1 FROM project_members others JOIN users members ON members.id = others.user_id " +
+				"WHERE others.project_id = project_members.project_id AND others.user_id <> project_members.user_id " +
+				"AND members.deleted_at IS NULL))").
+			Order("id").Limit(limit).Pluck("id", &ids)
+		if result.Error != nil || len(ids) == 0 {
+			return result.Error
+		}
+		if err := promoteProjectOwners(tx, ids); err != nil {
+			return err
+		}
+		var taskIDs []int
+		result = tx.Unscoped().Model(&model.Task{}).Where("user_id IN ? AND project_id IS NULL", ids).Pluck("id", &taskIDs)
+		if result.Error != nil {
+			return result.Error
+		}
+		var err error
+		if attachments, err = purgeTasks(tx, taskIDs); err != nil {
+			return err
+		}
+		for _, statement := range []string{
+			"DELETE FROM task_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id IN @ids)",
+			"DELETE FROM tags WHERE user_id IN @ids",
+			"DELETE FROM access_details WHERE user_id IN @ids",
+			"DELETE FROM api_keys WHERE user_id IN @ids",
+			"DELETE FROM recovery_codes WHERE user_id IN @ids",
+			"DELETE FROM password_resets WHERE user_id IN @ids",
+			"DELETE FROM project_members WHERE user_id IN @ids",
+			"DELETE FROM users WHERE id IN @ids",
+		} {
+			if dr := tx.Exec(statement, map[string]interface{}{"ids": ids}); dr.Error != nil {
+				return dr.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"before": before,
+			"error": err,
+		}).Errorln("[DB] Couldn't purge deleted users")
+		return nil, 0, err
+	}
+	if len(ids) > 0 {
+		logging.Logger.WithFields(logrus.Fields{
+			"ids": ids,
+		}).Infoln("[DB] Purged deleted users")
+	}
+	return attachments, len(ids), nil
+}
+
+// promoteProjectOwners makes another member the owner of the projects that the users with the specified ids are the
+// only owners of, before they're removed from them. Editors are preferred over viewers, and then the oldest members.
+// Members on the trash are never promoted
+func promoteProjectOwners(tx *gorm.DB, userIDs []int) error {
+	var projectIDs []int
+	result := tx.Model(&model.ProjectMember{}).Distinct("project_id").
+		Where("user_id IN ? AND role = ?", userIDs, model.ProjectRoleOwner).
+		Where("project_id NOT IN (?)", tx.Model(&model.ProjectMember{}).Select("project_id").
+			Where("user_id NOT IN ? AND role = ?", userIDs, model.ProjectRoleOwner)).
+		Pluck("project_id", &projectIDs)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, projectID := range projectIDs {
+		var member model.ProjectMember
+		result = tx.Joins("JOIN users ON users.id = project_members.user_id").
+			Where("project_members.project_id = ? AND project_members.user_id NOT IN ? AND users.deleted_at IS NULL",
+				projectID, userIDs).
+			Order("CASE project_members.role WHEN '" + model.ProjectRoleEditor + "' THEN 0 ELSE 1 END").
+			Order("project_members.created_at").
+			First(&member)
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Model(&model.ProjectMember{}).Where("project_id = ? AND user_id = ?", projectID, member.UserID).
+			UpdateColumn("role", model.ProjectRoleOwner)
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// purgeTasks permanently deletes the tasks with the specified ids, whether they're on the trash or not, along with
+// their tag associations, dependencies, comments and attachments, returning the deleted attachments. Their subtasks
+// become top-level tasks
+func purgeTasks(tx *gorm.DB, ids []int) ([]model.Attachment, error) {
+	var attachments []model.Attachment
+	if len(ids) == 0 {
+		return attachments, nil
+	}
+	if result := tx.Where("task_id IN ?", ids).Find(&attachments); result.Error != nil {
+		return nil, result.Error
+	}
+	for _, statement := range []string{
+		"DELETE FROM task_tags WHERE task_id IN @ids",
+		"DELETE FROM task_dependencies WHERE task_id IN @ids OR blocked_by_id IN @ids",
+		"DELETE FROM task_comments WHERE task_id IN @ids",
+		"DELETE FROM attachments WHERE task_id IN @ids",
+		"UPDATE tasks SET parent_id = NULL WHERE parent_id IN @ids",
+		"UPDATE tasks SET detached_from_id = NULL WHERE detached_from_id IN @ids",
+		"DELETE FROM tasks WHERE id IN @ids",
+	} {
+		if dr := tx.Exec(statement, map[string]interface{}{"ids": ids}); dr.Error != nil {
+			return nil, dr.Error
+		}
+	}
+	return attachments, nil
+}
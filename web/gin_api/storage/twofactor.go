@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"github.com/jomifepe/gin_api/logging"
+	"github.com/jomifepe/gin_api/model"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SetUserTOTPSecret stores a new TOTP secret for the user with the specified id. Two-factor authentication stays
+// disabled until the enrollment is confirmed with EnableUserTOTP.
+func (conn *DBConn) SetUserTOTPSecret(userID int, secret string) error {
+	result := conn.DB.Model(&model.User{ID: userID}).Updates(map[string]interface{}{
+		"totp_secret": secret,
+		"totp_enabled": false,
+		"version": gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't set user TOTP secret")
+		return result.Error
+	}
+	return nil
+}
+
+// EnableUserTOTP enables two-factor authentication for the user with the specified id, storing the time step of the
+// code that confirmed it and replacing its recovery codes with new ones, on the same transaction
+func (conn *DBConn) EnableUserTOTP(userID int, step int64, codeHashes []string) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		ur := tx.Model(&model.User{ID: userID}).Updates(map[string]interface{}{
+			"totp_enabled": true,
+			"totp_last_step": step,
+			"version": gorm.Expr("version + 1"),
+		})
+		if ur.Error != nil {
+			return ur.Error
+		}
+		if dr := tx.Delete(model.RecoveryCode{}, "user_id = ?", userID); dr.Error != nil {
+			return dr.Error
+		}
+		codes := make([]model.RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = model.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": err,
+		}).Errorln("[DB] Couldn't enable user TOTP")
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": userID,
+	}).Infoln("[DB] Enabled user TOTP")
+	return nil
+}
+
+// DisableUserTOTP disables two-factor authentication for the user with the specified id, deleting its secret and
+// recovery codes on the same transaction
+func (conn *DBConn) DisableUserTOTP(userID int) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		ur := tx.Model(&model.User{ID: userID}).Updates(map[string]interface{}{
+			"totp_enabled": false,
+			"totp_secret": "",
+			"totp_last_step": 0,
+			"version": gorm.Expr("version + 1"),
+		})
+		if ur.Error != nil {
+			return ur.Error
+		}
+		return tx.Delete(model.RecoveryCode{}, "user_id = ?", userID).Error
+	})
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": err,
+		}).Errorln("[DB] Couldn't disable user TOTP")
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"user_id": userID,
+	}).Infoln("[DB] Disabled user TOTP")
+	return nil
+}
+
+// UseTOTPStep records that the TOTP code of time step <step> was used by the user with the specified id.
+// Returns false if a code of that step, or a later one, was already used, meaning the code is being replayed.
+func (conn *DBConn) UseTOTPStep(userID int, step int64) (bool, error) {
+	result := conn.DB.Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't use TOTP step")
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetRecoveryCodes returns the unused recovery codes of the user with the specified id
+func (conn *DBConn) GetRecoveryCodes(userID int) ([]model.RecoveryCode, error) {
+	var codes []model.RecoveryCode
+	if result := conn.DB.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": userID,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't get recovery codes")
+		return []model.RecoveryCode{}, result.Error
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode marks the recovery code with the specified id as used.
+// Returns false if it was already used.
+func (conn *DBConn) UseRecoveryCode(id int) (bool, error) {
+	result := conn.DB.Model(&model.RecoveryCode{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"code_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't use recovery code")
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/jomifepe/gin_api/logging"
 	"github.com/jomifepe/gin_api/model"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserStore struct {
@@ -14,11 +16,12 @@ type UserStore struct {
 // NewAuthStore return an AuthStore.
 func NewUserStore(conn *DBConn) *UserStore {
 	return &UserStore{
-		DBConn{DB: conn.DB},
+		DBConn{DB: conn.DB, FullTextSearch: conn.FullTextSearch},
 	}
 }
 
 func (conn *DBConn) CreateUser(u model.User) (model.User, error) {
+	u.Version, u.DeletedAt = 1, gorm.DeletedAt{}
 	result := conn.DB.Create(&u)
 	if result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
@@ -39,22 +42,48 @@ func (conn *DBConn) CreateUser(u model.User) (model.User, error) {
 	return u, nil
 }
 
-// GetAllUsers returns all users from the database.
-// By default, it omits sensitive fields, like passwords.
+// GetAllUsers returns a page of the users that match the filters of <query>, along with the total number of matching
+// users. By default, it omits sensitive fields, like passwords.
 // In order to get all fields, pass in an empty string.
-func (conn *DBConn) GetAllUsers(omitFields ...string) ([]model.User, error) {
+func (conn *DBConn) GetAllUsers(query model.UserQuery, omitFields ...string) ([]model.User, int64, error) {
 	if len(omitFields) == 0 {
 		omitFields = []string{"password"}
 	}
-	var users []model.User
-	result := conn.DB.Omit(omitFields...).Find(&users)
+	var (
+		users []model.User
+		total int64
+	)
+	if result := conn.DB.Model(&model.User{}).Scopes(usersMatching(query)).Count(&total); result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't count users")
+		return []model.User{}, 0, result.Error
+	}
+	result := conn.DB.Omit(omitFields...).Scopes(usersMatching(query), paginate(query.Page)).Find(&users)
 	if result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
 			"error": result.Error,
 		}).Errorln("[DB] Couldn't get all users")
-		return []model.User{}, result.Error
+		return []model.User{}, 0, result.Error
+	}
+	return users, total, nil
+}
+
+// usersMatching is a gorm scope that restricts a query to the users that match the filters of <query>
+func usersMatching(query model.UserQuery) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(query.Email) > 0 {
+			db = db.Where("users.email ILIKE ?", containing(query.Email))
+		}
+		if len(query.Name) > 0 {
+			name := containing(query.Name)
+			db = db.Where("(users.first_name ILIKE ? OR users.last_name ILIKE ?)", name, name)
+		}
+		if query.Active != nil {
+			db = db.Where("users.active = ?", *query.Active)
+		}
+		return db
 	}
-	return users, nil
 }
 
 // GetAllUsers returns an existing user from the database, searches by <paramName> with the <param> value.
@@ -76,21 +105,37 @@ func (conn *DBConn) GetUserBy(paramName string, param interface{}, omitFields ..
 	return user, nil
 }
 
-func (conn *DBConn) UpdateUser(u model.User) (model.User, error) {
-	result := conn.DB.Model(&u).Select("first_name", "last_name", "email").Updates(u)
-	if result.Error != nil && result.RowsAffected > 0 {
-		logging.Logger.WithFields(logrus.Fields{
-			"user": u,
-			"error": result.Error,
-		}).Errorln("[DB] Couldn't update user")
-		return model.User{}, result.Error
+// UpdateUser updates the specified columns of an existing user, or its first_name, last_name and email if none are
+// specified, incrementing its version. If <u.Version> isn't 0, the user is only updated if that's still its version.
+// Returns gorm.ErrRecordNotFound if there's no such user, or model.ErrVersionConflict if it was changed since
+// <u.Version>.
+func (conn *DBConn) UpdateUser(u model.User, columns ...string) (model.User, error) {
+	if len(columns) == 0 {
+		columns = []string{"first_name", "last_name", "email"}
+	}
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := lockUser(tx, u.ID, u.Version)
+		if err != nil {
+			return err
+		}
+		u.Version = current.Version + 1
+		return tx.Model(&u).Select(append([]string{"version"}, columns...)).Updates(u).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrVersionConflict) {
+			logging.Logger.WithFields(logrus.Fields{
+				"user": u,
+				"error": err,
+			}).Errorln("[DB] Couldn't update user")
+		}
+		return model.User{}, err
 	}
 	updatedUser, err := conn.GetUserBy("id", u.ID)
 	if err != nil {
 		logging.Logger.WithFields(logrus.Fields{
 			"error": err,
 		}).Errorln("[DB] Couldn't get updated user")
-		return model.User{}, result.Error
+		return model.User{}, err
 	}
 	logging.Logger.WithFields(logrus.Fields{
 		"id": u.ID,
@@ -98,16 +143,107 @@ func (conn *DBConn) UpdateUser(u model.User) (model.User, error) {
 	return updatedUser, nil
 }
 
-func (conn *DBConn) DeleteUser(id int) error {
-	if result := conn.DB.Delete(&model.User{}, id); result.Error != nil && result.RowsAffected > 0 {
+// DeleteUser moves the user with the specified id to the trash, where it's kept until it's purged, so it can be
+// restored. If <version> isn't 0, the user is only deleted if that's still its version. Returns
+// gorm.ErrRecordNotFound if there's no such user, or if it's already on the trash, or model.ErrVersionConflict if it
+// was changed since <version>.
+func (conn *DBConn) DeleteUser(id int, version int) error {
+	err := conn.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockUser(tx, id, version); err != nil {
+			return err
+		}
+		return tx.Delete(&model.User{}, id).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, model.ErrVersionConflict) {
+			logging.Logger.WithFields(logrus.Fields{
+				"user_id": id,
+				"error": err,
+			}).Errorln("[DB] Couldn't delete user by id")
+		}
+		return err
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Moved existing user to the trash")
+	return nil
+}
+
+// lockUser locks the user with the specified id until the transaction ends, and returns its version. Returns
+// gorm.ErrRecordNotFound if there's no such user, or model.ErrVersionConflict if <version> isn't 0 nor the user version
+func lockUser(tx *gorm.DB, id int, version int) (model.User, error) {
+	var user model.User
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "version").First(&user, "users.id = ?", id)
+	if result.Error != nil {
+		return model.User{}, result.Error
+	}
+	if version != 0 && user.Version != version {
+		return model.User{}, model.ErrVersionConflict
+	}
+	return user, nil
+}
+
+// UpdateUserRole sets the role of the user with the specified id
+func (conn *DBConn) UpdateUserRole(id int, role string) error {
+	result := conn.DB.Model(&model.User{ID: id}).Updates(map[string]interface{}{
+		"role": role,
+		"version": gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": id,
+			"role": role,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't update user role")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+		"role": role,
+	}).Infoln("[DB] Updated user role")
+	return nil
+}
+
+// MarkUserVerified clears the pending state of the user with the specified id, after its email address was verified
+func (conn *DBConn) MarkUserVerified(id int) error {
+	result := conn.DB.Model(&model.User{ID: id}).Updates(map[string]interface{}{
+		"pending": false,
+		"version": gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
 		logging.Logger.WithFields(logrus.Fields{
 			"user_id": id,
 			"error": result.Error,
-		}).Errorln("[DB] Couldn't delete user by id")
+		}).Errorln("[DB] Couldn't mark user as verified")
 		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	logging.Logger.WithFields(logrus.Fields{
+		"id": id,
+	}).Infoln("[DB] Verified user email")
+	return nil
+}
+
+// UpdateUserPassword sets the password hash of the user with the specified id
+func (conn *DBConn) UpdateUserPassword(id int, passwordHash string) error {
+	result := conn.DB.Model(&model.User{ID: id}).Update("password", passwordHash)
+	if result.Error != nil {
+		logging.Logger.WithFields(logrus.Fields{
+			"user_id": id,
+			"error": result.Error,
+		}).Errorln("[DB] Couldn't update user password")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	logging.Logger.WithFields(logrus.Fields{
 		"id": id,
-	}).Infoln("[DB] Deleted existing user")
+	}).Infoln("[DB] Updated user password")
 	return nil
 }